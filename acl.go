@@ -0,0 +1,282 @@
+/*
+
+按方法授权（ACL）
+
+设置 Server.ACL 后，每个请求在认证之后、进入拦截器链和服务方法之前检查调用方身份是否可以调用 ServiceMethod，
+拒绝时返回 PermissionDenied。每次决策都以 Info 级别写入 Server.AuditLogger。
+
+调用方身份取认证结果 AuthInfo.Identity，没有认证结果时取 mTLS 客户端证书的 CommonName，都没有时为空串。
+
+策略的 JSON 格式：
+	{
+		"default": "deny",
+		"rules": [
+			{"effect": "deny",  "identities": ["guest*"], "methods": ["AdminService.*"]},
+			{"effect": "allow", "identities": ["admin"],  "methods": ["*"]},
+			{"effect": "allow", "methods": ["Foo.Sum", "_Reflection.*"]}
+		]
+	}
+
+methods 和 identities 使用 path.Match 的通配符语法，identities 为空时匹配任意调用方（包括匿名）。
+任意一条匹配的 deny 规则优先；否则有匹配的 allow 规则时放行；都不匹配时按 default，default 为空时拒绝。
+
+从文件加载的策略可以通过 Reload 重新读取，或者通过 Watch 定期检查文件的修改时间自动重新加载；
+新策略解析或校验失败时保留原来的策略。
+
+*/
+
+package myrpc
+
+import (
+	"MyRPC/status"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+const (
+	EffectAllow = "allow"
+	EffectDeny  = "deny"
+)
+
+type Rule struct {
+	Effect     string   `json:"effect"`
+	Identities []string `json:"identities,omitempty"`
+	Methods    []string `json:"methods"`
+}
+
+type Policy struct {
+	Default string `json:"default,omitempty"`
+	Rules   []Rule `json:"rules"`
+}
+
+// 检查 effect 和通配符的语法
+func (p *Policy) Validate() error {
+	if p.Default != "" && p.Default != EffectAllow && p.Default != EffectDeny {
+		return fmt.Errorf("acl: invalid default effect %q", p.Default)
+	}
+	for i, r := range p.Rules {
+		if r.Effect != EffectAllow && r.Effect != EffectDeny {
+			return fmt.Errorf("acl: rule %d: invalid effect %q", i, r.Effect)
+		}
+		if len(r.Methods) == 0 {
+			return fmt.Errorf("acl: rule %d: no methods", i)
+		}
+		if err := checkPatterns(r.Methods); err != nil {
+			return fmt.Errorf("acl: rule %d: %w", i, err)
+		}
+		if err := checkPatterns(r.Identities); err != nil {
+			return fmt.Errorf("acl: rule %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func checkPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("bad pattern %q", pattern)
+		}
+	}
+	return nil
+}
+
+// 返回决策结果和决定结果的规则下标，由 default 决定时下标为 -1
+func (p *Policy) Evaluate(identity, serviceMethod string) (allowed bool, rule int) {
+	rule = -1
+	for i, r := range p.Rules {
+		if !r.matches(identity, serviceMethod) {
+			continue
+		}
+		if r.Effect == EffectDeny {
+			return false, i
+		}
+		if rule < 0 {
+			rule = i
+		}
+	}
+	if rule >= 0 {
+		return true, rule
+	}
+	return p.Default == EffectAllow, -1
+}
+
+func (r *Rule) matches(identity, serviceMethod string) bool {
+	return matchAny(r.Methods, serviceMethod) && (len(r.Identities) == 0 || matchAny(r.Identities, identity))
+}
+
+func matchAny(patterns []string, s string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// 可以在运行时替换策略的 ACL，并发安全
+type ACL struct {
+	Logger *slog.Logger // 重新加载的日志，nil 时使用 slog.Default()
+
+	mu      sync.RWMutex
+	policy  *Policy
+	file    string
+	modTime time.Time
+}
+
+func NewACL(p *Policy) (*ACL, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return &ACL{policy: p}, nil
+}
+
+// 从 JSON 文件加载策略，之后可以 Reload / Watch
+func LoadACL(file string) (*ACL, error) {
+	a := &ACL{file: file}
+	if err := a.Reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *ACL) Policy() *Policy {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.policy
+}
+
+func (a *ACL) SetPolicy(p *Policy) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.policy = p
+	return nil
+}
+
+// 重新读取策略文件，失败时保留原来的策略
+func (a *ACL) Reload() error {
+	if a.file == "" {
+		return fmt.Errorf("acl: not loaded from a file")
+	}
+	fi, err := os.Stat(a.file)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(a.file)
+	if err != nil {
+		return err
+	}
+	var p Policy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return fmt.Errorf("acl: parse %s: %w", a.file, err)
+	}
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.policy, a.modTime = &p, fi.ModTime()
+	return nil
+}
+
+// 每隔 interval 检查一次策略文件，修改时间变化时重新加载；返回的 stop 用于停止检查，可以重复调用
+func (a *ACL) Watch(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				a.reloadIfChanged()
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
+
+func (a *ACL) reloadIfChanged() {
+	fi, err := os.Stat(a.file)
+	if err != nil {
+		a.logger().Warn("acl: stat policy file error", "file", a.file, "err", err)
+		return
+	}
+	a.mu.RLock()
+	changed := !fi.ModTime().Equal(a.modTime)
+	a.mu.RUnlock()
+	if !changed {
+		return
+	}
+	if err := a.Reload(); err != nil {
+		// 记下修改时间，文件再次修改之前不再重试
+		a.mu.Lock()
+		a.modTime = fi.ModTime()
+		a.mu.Unlock()
+		a.logger().Error("acl: reload policy error, keeping the previous policy", "file", a.file, "err", err)
+		return
+	}
+	a.logger().Info("acl: policy reloaded", "file", a.file)
+}
+
+func (a *ACL) logger() *slog.Logger {
+	if a.Logger != nil {
+		return a.Logger
+	}
+	return slog.Default()
+}
+
+// NewServer 的可选参数，在开始接受连接之前设置 Server.ACL
+func WithACL(acl *ACL) ServerOption {
+	return func(svr *Server) {
+		svr.ACL = acl
+	}
+}
+
+// 审计日志的输出，nil 时使用 Server 的 Logger
+func WithAuditLogger(logger *slog.Logger) ServerOption {
+	return func(svr *Server) {
+		svr.AuditLogger = logger
+	}
+}
+
+// 由 invoke 在认证之后调用
+func (svr *Server) authorize(ctx context.Context, info *ServerInfo) error {
+	if svr.ACL == nil {
+		return nil
+	}
+	// 认证得到的身份优先；Authenticator 没有给出身份时使用 mTLS 证书的 CN
+	identity := info.Peer.Identity()
+	if info.Auth != nil && info.Auth.Identity != "" {
+		identity = info.Auth.Identity
+	}
+	allowed, rule := svr.ACL.Policy().Evaluate(identity, info.ServiceMethod)
+
+	audit := svr.AuditLogger
+	if audit == nil {
+		audit = svr.logger()
+	}
+	decision := EffectAllow
+	if !allowed {
+		decision = EffectDeny
+	}
+	audit.InfoContext(ctx, "rpc audit", "decision", decision, "identity", identity,
+		"service_method", info.ServiceMethod, "remote_addr", info.RemoteAddr, "rule", rule)
+
+	if !allowed {
+		return status.Errorf(status.PermissionDenied, "server: %q is not allowed to call %s", identity, info.ServiceMethod)
+	}
+	return nil
+}
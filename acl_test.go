@@ -0,0 +1,233 @@
+package myrpc
+
+import (
+	"MyRPC/registry"
+	"MyRPC/status"
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPolicyEvaluate(t *testing.T) {
+	p := &Policy{
+		Default: EffectDeny,
+		Rules: []Rule{
+			{Effect: EffectAllow, Identities: []string{"admin"}, Methods: []string{"*"}},
+			{Effect: EffectDeny, Identities: []string{"guest*", "admin"}, Methods: []string{"Admin.*"}},
+			{Effect: EffectAllow, Methods: []string{"Public.*"}},
+		},
+	}
+	if err := p.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		identity, method string
+		allowed          bool
+		rule             int
+	}{
+		{"admin", "Foo.Sum", true, 0},
+		{"admin", "Admin.Drop", false, 1}, // deny 优先于排在前面的 allow
+		{"guest-1", "Admin.Drop", false, 1},
+		{"guest-1", "Public.Get", true, 2},
+		{"", "Public.Get", true, 2}, // identities 为空时匹配匿名调用方
+		{"bob", "Foo.Sum", false, -1},
+	} {
+		allowed, rule := p.Evaluate(tc.identity, tc.method)
+		if allowed != tc.allowed || rule != tc.rule {
+			t.Errorf("Evaluate(%q, %q) = %v, %d; want %v, %d", tc.identity, tc.method, allowed, rule, tc.allowed, tc.rule)
+		}
+	}
+
+	p.Default = EffectAllow
+	if allowed, rule := p.Evaluate("bob", "Foo.Sum"); !allowed || rule != -1 {
+		t.Errorf("default allow: Evaluate = %v, %d", allowed, rule)
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	for name, p := range map[string]*Policy{
+		"default": {Default: "maybe"},
+		"effect":  {Rules: []Rule{{Effect: "maybe", Methods: []string{"*"}}}},
+		"methods": {Rules: []Rule{{Effect: EffectAllow}}},
+		"pattern": {Rules: []Rule{{Effect: EffectAllow, Methods: []string{"Foo.["}}}},
+	} {
+		if err := p.Validate(); err == nil {
+			t.Errorf("%s: Validate accepted an invalid policy", name)
+		}
+	}
+}
+
+// 并发安全的日志输出
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestACLServer(t *testing.T) {
+	acl, err := NewACL(&Policy{Rules: []Rule{
+		{Effect: EffectDeny, Identities: []string{"bob"}, Methods: []string{"Secure.*"}},
+		{Effect: EffectAllow, Methods: []string{"*"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var audit syncBuffer
+	addr := startTestServer(t, &Server{
+		Authenticator: NewTokenAuthenticator(map[string]string{"t-alice": "alice", "t-bob": "bob"}),
+		ACL:           acl,
+		AuditLogger:   slog.New(slog.NewTextHandler(&audit, nil)),
+	}, Secure{})
+
+	alice := dialTestClient(t, addr, &Option{Credentials: NewTokenCredentials("t-alice")})
+	if _, err := callSecure(alice, SecureArgs{}); err != nil {
+		t.Fatal(err)
+	}
+	bob := dialTestClient(t, addr, &Option{Credentials: NewTokenCredentials("t-bob")})
+	if _, err := callSecure(bob, SecureArgs{}); status.CodeOf(err) != status.PermissionDenied {
+		t.Fatalf("err = %v, want PermissionDenied", err)
+	}
+
+	log := audit.String()
+	for _, want := range []string{"decision=allow identity=alice", "decision=deny identity=bob"} {
+		if !strings.Contains(log, want) {
+			t.Errorf("audit log does not contain %q:\n%s", want, log)
+		}
+	}
+}
+
+func TestACLReload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "policy.json")
+	write := func(policy string, modTime time.Time) {
+		if err := os.WriteFile(file, []byte(policy), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		// 保证修改时间变化，不依赖文件系统的时间精度
+		if err := os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor := func(cond func() bool) bool {
+		for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+			if cond() {
+				return true
+			}
+		}
+		return false
+	}
+
+	now := time.Now()
+	write(`{"default": "deny", "rules": [{"effect": "allow", "identities": ["alice"], "methods": ["*"]}]}`, now)
+	acl, err := LoadACL(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acl.Logger = slog.New(slog.NewTextHandler(&syncBuffer{}, nil))
+	stop := acl.Watch(10 * time.Millisecond)
+	defer stop()
+	if allowed, _ := acl.Policy().Evaluate("bob", "Foo.Sum"); allowed {
+		t.Fatal("bob allowed by the initial policy")
+	}
+
+	write(`{"default": "allow"}`, now.Add(time.Second))
+	if !waitFor(func() bool { allowed, _ := acl.Policy().Evaluate("bob", "Foo.Sum"); return allowed }) {
+		t.Fatal("policy was not reloaded")
+	}
+
+	// 非法的策略不生效，保留原来的策略
+	write(`{"rules": [{"effect": "maybe", "methods": ["*"]}]}`, now.Add(2*time.Second))
+	time.Sleep(100 * time.Millisecond)
+	if p := acl.Policy(); p.Default != EffectAllow {
+		t.Fatalf("invalid policy replaced the previous one: %+v", p)
+	}
+	if err := acl.Reload(); err == nil {
+		t.Fatal("Reload accepted an invalid policy")
+	}
+}
+
+// 通过 NewServer 的参数设置的认证和 ACL 从第一个请求开始生效
+func TestNewServerOptions(t *testing.T) {
+	reg := httptest.NewServer(registry.New(0))
+	defer reg.Close()
+	acl, err := NewACL(&Policy{Rules: []Rule{{Effect: EffectAllow, Identities: []string{"alice"}, Methods: []string{"*"}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var audit syncBuffer
+	ch := make(chan *Server)
+	go NewServer(reg.URL, ch,
+		WithLogger(slog.New(slog.NewTextHandler(&syncBuffer{}, nil))),
+		WithAuthenticator(NewTokenAuthenticator(map[string]string{"t-alice": "alice", "t-bob": "bob"})),
+		WithACL(acl),
+		WithAuditLogger(slog.New(slog.NewTextHandler(&audit, nil))),
+	)
+	svr := <-ch
+	defer svr.Close()
+	if err := svr.Register(Secure{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for token, want := range map[string]status.Code{"": status.Unauthenticated, "t-bob": status.PermissionDenied, "t-alice": status.OK} {
+		opt := &Option{}
+		if token != "" {
+			opt.Credentials = NewTokenCredentials(token)
+		}
+		client := dialTestClient(t, svr.Address, opt)
+		if _, err := callSecure(client, SecureArgs{}); status.CodeOf(err) != want {
+			t.Errorf("token %q: err = %v, want %v", token, err, want)
+		}
+	}
+	if !strings.Contains(audit.String(), "decision=deny identity=bob") {
+		t.Errorf("audit log: %s", audit.String())
+	}
+}
+
+// Authenticator 没有给出身份时（如只检查请求来源），ACL 使用 mTLS 证书的 CN
+func TestACLMutualTLSIdentity(t *testing.T) {
+	pki := newTestPKI(t)
+	acl, err := NewACL(&Policy{Default: EffectDeny, Rules: []Rule{{Effect: EffectAllow, Identities: []string{"alice"}, Methods: []string{"*"}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var audit syncBuffer
+	addr := startTestServer(t, &Server{
+		TLSConfig: pki.serverConfig(true),
+		Authenticator: AuthenticatorFunc(func(context.Context, *AuthRequest) (*AuthInfo, error) {
+			return &AuthInfo{Scheme: "mtls"}, nil
+		}),
+		ACL:         acl,
+		AuditLogger: slog.New(slog.NewTextHandler(&audit, nil)),
+	}, Secure{})
+
+	alice := dialTestClient(t, addr, &Option{TLSConfig: pki.clientConfig("alice")})
+	if reply, err := callSecure(alice, SecureArgs{1, "x"}); err != nil {
+		t.Fatalf("alice: Echo = %q, %v", reply, err)
+	}
+	bob := dialTestClient(t, addr, &Option{TLSConfig: pki.clientConfig("bob")})
+	if _, err := callSecure(bob, SecureArgs{}); status.CodeOf(err) != status.PermissionDenied {
+		t.Fatalf("bob: err = %v, want PermissionDenied", err)
+	}
+	for _, want := range []string{"decision=allow identity=alice", "decision=deny identity=bob"} {
+		if !strings.Contains(audit.String(), want) {
+			t.Errorf("audit log does not contain %q:\n%s", want, audit.String())
+		}
+	}
+}
@@ -0,0 +1,372 @@
+/*
+
+认证
+
+服务端：设置 Server.Authenticator 后，每个请求（包括流式和单向调用）在进入拦截器链之前先经过认证，
+       失败时返回 Unauthenticated；认证结果通过 ServerInfo.Auth 交给拦截器，通过 AuthFromContext 交给服务方法
+客户端：设置 Option.Credentials 后，Call / Notify / Batch / Stream 在发出请求前向它索取凭证，附加到请求元数据中；
+       Option.AuthMetadata 在握手时随 Option 发送一次，服务端通过 Peer.AuthMetadata 读取
+
+凭证统一放在 authorization 元数据中，格式与 HTTP Authorization 头相同，网关直接转发 HTTP 请求的 Authorization 头：
+	Bearer <token>
+	HMAC-SHA256 key=<key id>,ts=<unix 秒>,nonce=<随机串>,sig=<hex 签名>
+
+HMAC 签名覆盖请求头和 body 在连接上的原始字节，以换行分隔：
+	ServiceMethod \n Seq \n Type \n Timeout（纳秒） \n ts \n nonce \n json(除 authorization 以外的元数据) \n hex(sha256(body))
+Type 区分普通调用、单向调用和流，Timeout 是客户端告知的剩余时间，二者被改动都会影响服务端如何处理请求。
+客户端在请求编码之后签名（RequestSigner），body 以 codec.RawBody 原样发送；服务端对读到的同样的字节验证，
+不依赖两端参数类型的编码结果是否一致。因为签名包含 Seq，HMAC 只能由客户端自己签名，不能经过网关转发。
+ts 与服务端时间相差超过 Window 的请求被拒绝；Window 内每个 nonce 只能使用一次，防止重放。
+
+*/
+
+package myrpc
+
+import (
+	"MyRPC/codec"
+	"MyRPC/metadata"
+	"MyRPC/status"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 携带凭证的元数据 key
+const AuthorizationKey = "authorization"
+
+// 认证时可以拿到的信息
+type AuthRequest struct {
+	ServiceMethod string
+	Seq           uint64
+	Type          codec.MsgType // 请求帧的类型，区分普通调用、单向调用和流
+	Timeout       time.Duration // 请求头中客户端的剩余时间，0 表示不限制
+	Metadata      metadata.MD   // 请求头中的元数据
+	Peer          *Peer         // 连接信息，包括握手时发送的 AuthMetadata 和 mTLS 证书
+	Args          interface{}   // 解码后的参数
+
+	// body 在连接上的原始字节，只有客户端的 Credentials 实现了 RequestSigner 时才非 nil
+	Body []byte
+}
+
+// 认证结果
+type AuthInfo struct {
+	Identity string // 调用方身份，如 token 对应的用户名、HMAC 的 key id
+	Scheme   string // 认证方式，如 "bearer"、"hmac"
+}
+
+// 服务端认证接口，返回的 error 不是 *status.Error 时按 Unauthenticated 处理
+type Authenticator interface {
+	Authenticate(ctx context.Context, req *AuthRequest) (*AuthInfo, error)
+}
+
+// 函数形式的 Authenticator
+type AuthenticatorFunc func(ctx context.Context, req *AuthRequest) (*AuthInfo, error)
+
+func (f AuthenticatorFunc) Authenticate(ctx context.Context, req *AuthRequest) (*AuthInfo, error) {
+	return f(ctx, req)
+}
+
+// 客户端凭证，每次发出请求前调用，返回的元数据附加到请求中，覆盖 ctx 中的同名 key
+type Credentials interface {
+	RequestMetadata(ctx context.Context, serviceMethod string, args interface{}) (metadata.MD, error)
+}
+
+// 需要对整个请求签名的 Credentials 额外实现这个接口
+// 在请求头确定（已经分配 Seq）、body 编码之后调用，返回的元数据加入请求头，h 不应被修改；
+// body 原样发送，服务端通过 AuthRequest.Body 拿到同样的字节。Codec 需要实现 codec.RawBodyCodec
+type RequestSigner interface {
+	SignRequest(h *codec.Header, body []byte) (metadata.MD, error)
+}
+
+// NewServer 的可选参数，在开始接受连接之前设置 Server.Authenticator
+func WithAuthenticator(a Authenticator) ServerOption {
+	return func(svr *Server) {
+		svr.Authenticator = a
+	}
+}
+
+type authKey struct{}
+
+// 服务方法通过 ctx 拿到认证结果，没有设置 Authenticator 时返回 false
+func AuthFromContext(ctx context.Context) (*AuthInfo, bool) {
+	a, ok := ctx.Value(authKey{}).(*AuthInfo)
+	return a, ok
+}
+
+// 由 invoke 在进入拦截器链之前调用
+func (svr *Server) authenticate(ctx context.Context, info *ServerInfo, req *Request) (context.Context, error) {
+	if svr.Authenticator == nil {
+		return ctx, nil
+	}
+	a, err := svr.Authenticator.Authenticate(ctx, &AuthRequest{
+		ServiceMethod: info.ServiceMethod,
+		Seq:           req.H.Seq,
+		Type:          req.H.Type,
+		Timeout:       req.H.Timeout,
+		Metadata:      info.Metadata,
+		Peer:          info.Peer,
+		Args:          req.argv.Interface(),
+		Body:          req.rawBody,
+	})
+	if err != nil {
+		var se *status.Error
+		if !errors.As(err, &se) {
+			err = status.Errorf(status.Unauthenticated, "server: %v", err)
+		}
+		return ctx, err
+	}
+	if a == nil {
+		a = &AuthInfo{}
+	}
+	info.Auth = a
+	return context.WithValue(ctx, authKey{}, a), nil
+}
+
+// 请求元数据优先，其次是握手时发送的元数据
+func authorization(req *AuthRequest) string {
+	if v := req.Metadata.Get(AuthorizationKey); v != "" {
+		return v
+	}
+	if req.Peer != nil {
+		return req.Peer.AuthMetadata.Get(AuthorizationKey)
+	}
+	return ""
+}
+
+// 随请求发送的元数据：ctx 中的 outgoing 元数据、traceparent，以及 Option.Credentials 提供的凭证
+func (client *Client) requestMetadata(ctx context.Context, serviceMethod string, args interface{}) (metadata.MD, error) {
+	md := outgoingMetadata(ctx)
+	if client.opt.Credentials == nil {
+		return md, nil
+	}
+	cred, err := client.opt.Credentials.RequestMetadata(ctx, serviceMethod, args)
+	if err != nil {
+		return nil, status.Errorf(status.Unauthenticated, "client: credentials for %s: %v", serviceMethod, err)
+	}
+	return metadata.Join(md, cred), nil
+}
+
+// Credentials 实现了 RequestSigner 时编码并签名，返回要写出的 body（codec.RawBody），否则原样返回 args
+// 调用方需要持有 client.sending，h 的 Seq 需要已经分配
+func (client *Client) signRequest(h *codec.Header, args interface{}) (interface{}, error) {
+	signer, ok := client.opt.Credentials.(RequestSigner)
+	if !ok {
+		return args, nil
+	}
+	rc, ok := client.cc.(codec.RawBodyCodec)
+	if !ok {
+		return nil, status.Errorf(status.Unauthenticated, "client: codec %s cannot sign requests", client.opt.CodecType)
+	}
+	body, err := rc.MarshalBody(args)
+	if err != nil {
+		return nil, err
+	}
+	md, err := signer.SignRequest(h, body)
+	if err != nil {
+		return nil, status.Errorf(status.Unauthenticated, "client: sign %s: %v", h.ServiceMethod, err)
+	}
+	// 不修改调用方的元数据，Batch 中的多个调用可能共享同一个 map
+	h.Metadata = metadata.Join(h.Metadata, md)
+	h.RawBody = true
+	return body, nil
+}
+
+// ---- Bearer token ----
+
+// 以 Bearer token 认证，Tokens 为 token 到调用方身份的映射
+type TokenAuthenticator struct {
+	Tokens map[string]string
+}
+
+func NewTokenAuthenticator(tokens map[string]string) *TokenAuthenticator {
+	return &TokenAuthenticator{Tokens: tokens}
+}
+
+func (a *TokenAuthenticator) Authenticate(_ context.Context, req *AuthRequest) (*AuthInfo, error) {
+	token, ok := strings.CutPrefix(authorization(req), "Bearer ")
+	if !ok || token == "" {
+		return nil, status.New(status.Unauthenticated, "server: missing bearer token")
+	}
+	// 逐个比较，避免通过耗时推测 token
+	identity, found := "", false
+	for t, id := range a.Tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			identity, found = id, true
+		}
+	}
+	if !found {
+		return nil, status.New(status.Unauthenticated, "server: invalid bearer token")
+	}
+	return &AuthInfo{Identity: identity, Scheme: "bearer"}, nil
+}
+
+type tokenCredentials string
+
+// 每个请求携带 Bearer token
+func NewTokenCredentials(token string) Credentials {
+	return tokenCredentials(token)
+}
+
+func (t tokenCredentials) RequestMetadata(context.Context, string, interface{}) (metadata.MD, error) {
+	return metadata.Pairs(AuthorizationKey, "Bearer "+string(t)), nil
+}
+
+// ---- HMAC 签名 ----
+
+const (
+	hmacScheme        = "HMAC-SHA256"
+	defaultHMACWindow = 5 * time.Minute
+)
+
+// 以 HMAC-SHA256 签名认证，Keys 为 key id 到密钥的映射
+type HMACAuthenticator struct {
+	Keys   map[string][]byte
+	Window time.Duration // 允许的时间偏差，0 表示 5 分钟
+
+	mu        sync.Mutex
+	nonces    map[string]time.Time // key id + nonce -> 过期时间
+	lastSweep time.Time
+}
+
+func NewHMACAuthenticator(keys map[string][]byte, window time.Duration) *HMACAuthenticator {
+	return &HMACAuthenticator{Keys: keys, Window: window}
+}
+
+func (a *HMACAuthenticator) window() time.Duration {
+	if a.Window > 0 {
+		return a.Window
+	}
+	return defaultHMACWindow
+}
+
+func (a *HMACAuthenticator) Authenticate(_ context.Context, req *AuthRequest) (*AuthInfo, error) {
+	params, ok := strings.CutPrefix(req.Metadata.Get(AuthorizationKey), hmacScheme+" ")
+	if !ok {
+		return nil, status.New(status.Unauthenticated, "server: missing hmac signature")
+	}
+	if req.Body == nil {
+		return nil, status.New(status.Unauthenticated, "server: hmac signed request without a raw body")
+	}
+	fields := parseHMACParams(params)
+	keyID, ts, nonce, sig := fields["key"], fields["ts"], fields["nonce"], fields["sig"]
+	secret, ok := a.Keys[keyID]
+	if !ok || ts == "" || nonce == "" || sig == "" {
+		return nil, status.New(status.Unauthenticated, "server: invalid hmac signature")
+	}
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return nil, status.New(status.Unauthenticated, "server: invalid hmac timestamp")
+	}
+	signedAt, now := time.Unix(sec, 0), time.Now()
+	if d := now.Sub(signedAt); d > a.window() || d < -a.window() {
+		return nil, status.New(status.Unauthenticated, "server: hmac timestamp out of window")
+	}
+	h := &codec.Header{ServiceMethod: req.ServiceMethod, Seq: req.Seq, Type: req.Type, Timeout: req.Timeout, Metadata: req.Metadata}
+	expected, err := hmacSign(secret, h, ts, nonce, req.Body)
+	if err != nil {
+		return nil, status.Errorf(status.Unauthenticated, "server: hmac: %v", err)
+	}
+	if !hmac.Equal([]byte(expected), []byte(sig)) {
+		return nil, status.New(status.Unauthenticated, "server: hmac signature mismatch")
+	}
+	// 签名正确之后再记录 nonce，伪造的请求不会占用缓存
+	if !a.useNonce(keyID+"\n"+nonce, signedAt.Add(a.window()), now) {
+		return nil, status.New(status.Unauthenticated, "server: hmac nonce already used")
+	}
+	return &AuthInfo{Identity: keyID, Scheme: "hmac"}, nil
+}
+
+// nonce 只需要保存到它对应的 ts 超出时间窗口为止，之后的重放会被时间检查拒绝
+func (a *HMACAuthenticator) useNonce(key string, expire, now time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.nonces == nil {
+		a.nonces = make(map[string]time.Time)
+	}
+	if now.Sub(a.lastSweep) > a.window() {
+		for k, exp := range a.nonces {
+			if exp.Before(now) {
+				delete(a.nonces, k)
+			}
+		}
+		a.lastSweep = now
+	}
+	if exp, ok := a.nonces[key]; ok && !exp.Before(now) {
+		return false
+	}
+	a.nonces[key] = expire
+	return true
+}
+
+func parseHMACParams(s string) map[string]string {
+	ret := make(map[string]string)
+	for _, kv := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(kv), "=")
+		if ok {
+			ret[k] = v
+		}
+	}
+	return ret
+}
+
+// 签名 h 的 ServiceMethod、Seq、Type、Timeout、元数据和 body；元数据按 JSON 编码（key 有序），不包括携带签名的 authorization
+func hmacSign(secret []byte, h *codec.Header, ts, nonce string, body []byte) (string, error) {
+	signed := make(map[string]string, len(h.Metadata))
+	for k, v := range h.Metadata {
+		if k != AuthorizationKey {
+			signed[k] = v
+		}
+	}
+	mdJSON, err := json.Marshal(signed)
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(h.ServiceMethod + "\n" + strconv.FormatUint(h.Seq, 10) + "\n" +
+		strconv.Itoa(int(h.Type)) + "\n" + strconv.FormatInt(int64(h.Timeout), 10) + "\n" + ts + "\n" + nonce + "\n"))
+	mac.Write(mdJSON)
+	mac.Write([]byte("\n" + hex.EncodeToString(digest[:])))
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+type hmacCredentials struct {
+	keyID  string
+	secret []byte
+}
+
+var _ RequestSigner = (*hmacCredentials)(nil)
+
+// 每个请求使用 keyID 对应的密钥签名
+func NewHMACCredentials(keyID string, secret []byte) Credentials {
+	return &hmacCredentials{keyID: keyID, secret: secret}
+}
+
+// 签名在 SignRequest 中完成
+func (c *hmacCredentials) RequestMetadata(context.Context, string, interface{}) (metadata.MD, error) {
+	return nil, nil
+}
+
+func (c *hmacCredentials) SignRequest(h *codec.Header, body []byte) (metadata.MD, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return nil, err
+	}
+	ts, nonce := strconv.FormatInt(time.Now().Unix(), 10), hex.EncodeToString(b[:])
+	sig, err := hmacSign(c.secret, h, ts, nonce, body)
+	if err != nil {
+		return nil, err
+	}
+	value := fmt.Sprintf("%s key=%s,ts=%s,nonce=%s,sig=%s", hmacScheme, c.keyID, ts, nonce, sig)
+	return metadata.Pairs(AuthorizationKey, value), nil
+}
@@ -0,0 +1,193 @@
+package myrpc
+
+import (
+	"MyRPC/codec"
+	"MyRPC/metadata"
+	"MyRPC/status"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+type SecureArgs struct {
+	A int
+	B string
+}
+
+type Secure struct{}
+
+// 返回 "<身份>:<A>:<B>"
+func (Secure) Echo(ctx context.Context, args SecureArgs, reply *string) error {
+	identity := "<none>"
+	if a, ok := AuthFromContext(ctx); ok {
+		identity = a.Identity
+	}
+	*reply = fmt.Sprintf("%s:%d:%s", identity, args.A, args.B)
+	return nil
+}
+
+func callSecure(client *Client, args interface{}) (string, error) {
+	var reply string
+	err := client.Call(context.Background(), "Secure.Echo", args, &reply)
+	return reply, err
+}
+
+func TestTokenAuth(t *testing.T) {
+	addr := startTestServer(t, &Server{Authenticator: NewTokenAuthenticator(map[string]string{"t-alice": "alice"})}, Secure{})
+
+	client := dialTestClient(t, addr, &Option{Credentials: NewTokenCredentials("t-alice")})
+	if reply, err := callSecure(client, SecureArgs{1, "x"}); err != nil || reply != "alice:1:x" {
+		t.Fatalf("Echo = %q, %v", reply, err)
+	}
+
+	// 握手时发送的 token 对连接上的每个请求都有效
+	client = dialTestClient(t, addr, &Option{AuthMetadata: metadata.Pairs(AuthorizationKey, "Bearer t-alice")})
+	if reply, err := callSecure(client, SecureArgs{2, "y"}); err != nil || reply != "alice:2:y" {
+		t.Fatalf("Echo = %q, %v", reply, err)
+	}
+
+	for name, opt := range map[string]*Option{
+		"no credentials": {},
+		"wrong token":    {Credentials: NewTokenCredentials("t-bob")},
+	} {
+		client := dialTestClient(t, addr, opt)
+		if _, err := callSecure(client, SecureArgs{}); status.CodeOf(err) != status.Unauthenticated {
+			t.Errorf("%s: err = %v, want Unauthenticated", name, err)
+		}
+	}
+}
+
+// 客户端使用与服务端不同但兼容的参数类型，JSON 编码的字段顺序也不同
+type reorderedArgs struct {
+	B string
+	A int
+}
+
+func TestHMACAuth(t *testing.T) {
+	secret := []byte("s3cret")
+	addr := startTestServer(t, &Server{Authenticator: NewHMACAuthenticator(map[string][]byte{"k1": secret}, 0)}, Secure{})
+
+	for _, ct := range []codec.Type{codec.GobType, codec.JsonType, codec.GobFrameType, codec.JsonFrameType} {
+		client := dialTestClient(t, addr, &Option{CodecType: ct, Credentials: NewHMACCredentials("k1", secret)})
+		for _, args := range []interface{}{SecureArgs{1, "x"}, &SecureArgs{1, "x"}, reorderedArgs{B: "x", A: 1}} {
+			if reply, err := callSecure(client, args); err != nil || reply != "k1:1:x" {
+				t.Fatalf("%s %T: Echo = %q, %v", ct, args, reply, err)
+			}
+		}
+		// 带截止时间的调用签名中包含 Timeout，两端要一致
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		var reply string
+		err := client.Call(ctx, "Secure.Echo", SecureArgs{2, "y"}, &reply)
+		cancel()
+		if err != nil || reply != "k1:2:y" {
+			t.Fatalf("%s: Echo with deadline = %q, %v", ct, reply, err)
+		}
+		if err := client.Notify(context.Background(), "Secure.Echo", SecureArgs{}); err != nil {
+			t.Fatal(err)
+		}
+		calls := []*Call{
+			{ServiceMethod: "Secure.Echo", Args: SecureArgs{1, "a"}, Reply: new(string)},
+			{ServiceMethod: "Secure.Echo", Args: SecureArgs{2, "b"}, Reply: new(string)},
+		}
+		if err := client.Batch(context.Background(), calls); err != nil {
+			t.Fatal(err)
+		}
+		for _, call := range calls {
+			if call.Error != nil {
+				t.Fatalf("%s: batch call: %v", ct, call.Error)
+			}
+		}
+	}
+
+	for name, cred := range map[string]Credentials{
+		"wrong secret": NewHMACCredentials("k1", []byte("guess")),
+		"unknown key":  NewHMACCredentials("k2", secret),
+		"bearer":       NewTokenCredentials("k1"),
+	} {
+		client := dialTestClient(t, addr, &Option{Credentials: cred})
+		if _, err := callSecure(client, SecureArgs{}); status.CodeOf(err) != status.Unauthenticated {
+			t.Errorf("%s: err = %v, want Unauthenticated", name, err)
+		}
+	}
+}
+
+// 在签名之后篡改请求
+type tamperCredentials struct {
+	RequestSigner
+	tamper func(h *codec.Header, body []byte, md metadata.MD) metadata.MD
+}
+
+func (c *tamperCredentials) RequestMetadata(context.Context, string, interface{}) (metadata.MD, error) {
+	return nil, nil
+}
+
+func (c *tamperCredentials) SignRequest(h *codec.Header, body []byte) (metadata.MD, error) {
+	md, err := c.RequestSigner.SignRequest(h, body)
+	if err != nil {
+		return nil, err
+	}
+	return c.tamper(h, body, md), nil
+}
+
+func TestHMACTamper(t *testing.T) {
+	secret := []byte("s3cret")
+	addr := startTestServer(t, &Server{Authenticator: NewHMACAuthenticator(map[string][]byte{"k1": secret}, 0)}, Secure{})
+	signer := NewHMACCredentials("k1", secret).(RequestSigner)
+
+	for name, tamper := range map[string]func(h *codec.Header, body []byte, md metadata.MD) metadata.MD{
+		// 签名之外加入的元数据
+		"metadata": func(h *codec.Header, body []byte, md metadata.MD) metadata.MD {
+			return metadata.Join(md, metadata.Pairs("tenant", "other"))
+		},
+		// 签名针对另一个 body
+		"body": func(h *codec.Header, body []byte, md metadata.MD) metadata.MD {
+			md, _ = signer.SignRequest(h, append([]byte{0}, body...))
+			return md
+		},
+		// 签名针对另一个 Seq
+		"seq": func(h *codec.Header, body []byte, md metadata.MD) metadata.MD {
+			other := *h
+			other.Seq++
+			md, _ = signer.SignRequest(&other, body)
+			return md
+		},
+		// 签名针对单向调用，实际发出的是普通调用
+		"type": func(h *codec.Header, body []byte, md metadata.MD) metadata.MD {
+			other := *h
+			other.Type = codec.MsgOneWay
+			md, _ = signer.SignRequest(&other, body)
+			return md
+		},
+		// 签名针对另一个剩余时间，防止改大客户端的超时
+		"timeout": func(h *codec.Header, body []byte, md metadata.MD) metadata.MD {
+			other := *h
+			other.Timeout = time.Hour
+			md, _ = signer.SignRequest(&other, body)
+			return md
+		},
+	} {
+		client := dialTestClient(t, addr, &Option{Credentials: &tamperCredentials{signer, tamper}})
+		if _, err := callSecure(client, SecureArgs{1, "x"}); status.CodeOf(err) != status.Unauthenticated {
+			t.Errorf("%s: err = %v, want Unauthenticated", name, err)
+		}
+	}
+}
+
+func TestHMACReplay(t *testing.T) {
+	secret := []byte("s3cret")
+	auth := NewHMACAuthenticator(map[string][]byte{"k1": secret}, 0)
+	h := &codec.Header{ServiceMethod: "Secure.Echo", Seq: 7, Metadata: metadata.Pairs("tenant", "a")}
+	body := []byte("body")
+	md, err := NewHMACCredentials("k1", secret).(RequestSigner).SignRequest(h, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &AuthRequest{ServiceMethod: h.ServiceMethod, Seq: h.Seq, Metadata: metadata.Join(h.Metadata, md), Body: body}
+	if a, err := auth.Authenticate(context.Background(), req); err != nil || a.Identity != "k1" {
+		t.Fatalf("Authenticate = %v, %v", a, err)
+	}
+	if _, err := auth.Authenticate(context.Background(), req); status.CodeOf(err) != status.Unauthenticated {
+		t.Fatalf("replayed request: err = %v, want Unauthenticated", err)
+	}
+}
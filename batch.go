@@ -0,0 +1,128 @@
+/*
+
+批量调用：一次性发出多个请求，只加一次 sending 锁、只 Flush 一次
+
+每个请求仍然是一条独立的 MsgCall，服务端按普通请求并发处理；
+服务端写响应时会合并 Flush（见 sendResponse），一批请求的响应通常也只需要很少几次写连接。
+Codec 没有实现 codec.BufferedWriter 时退化为逐条 Write。
+
+*/
+
+package myrpc
+
+import (
+	"MyRPC/codec"
+	"MyRPC/metadata"
+	"MyRPC/status"
+	"context"
+)
+
+// 批量发起调用，calls 中每个 Call 需要设置 ServiceMethod、Args 和 Reply
+// 所有调用结束或 ctx 结束时返回，每个调用的结果写入各自的 Error 和 Reply
+// ctx 结束时尚未完成的调用会通知服务端取消，Error 为 ctx 对应的错误，此时 Batch 返回同样的错误
+// ctx 的元数据和截止时间作用于每个调用；批量调用不经过拦截器，与 GoCall 相同
+// Option.Credentials 为每个调用分别提供凭证，获取凭证失败的调用不会发出
+func (client *Client) Batch(ctx context.Context, calls []*Call) error {
+	if len(calls) == 0 {
+		return nil
+	}
+	md := outgoingMetadata(ctx)
+	deadline, _ := ctx.Deadline()
+	done := make(chan *Call, len(calls))
+	send := make([]*Call, 0, len(calls))
+	for _, call := range calls {
+		call.Done = done
+		call.Error = nil
+		if call.Metadata == nil {
+			call.Metadata = md
+		}
+		call.deadline = deadline
+		if client.opt.Credentials != nil {
+			cred, err := client.opt.Credentials.RequestMetadata(ctx, call.ServiceMethod, call.Args)
+			if err != nil {
+				call.Error = status.Errorf(status.Unauthenticated, "client: credentials for %s: %v", call.ServiceMethod, err)
+				call.done()
+				continue
+			}
+			call.Metadata = metadata.Join(call.Metadata, cred)
+		}
+		send = append(send, call)
+	}
+	client.sendBatch(send)
+
+	remaining := len(calls)
+	for remaining > 0 {
+		select {
+		case <-done:
+			remaining--
+		case <-ctx.Done():
+			err := status.Errorf(status.Convert(ctx.Err()).Code, "client: batch: %v", ctx.Err())
+			for _, call := range calls {
+				if client.removePendingCall(call) {
+					client.sendCancel(call.Seq)
+					call.Error = err
+					call.done()
+				}
+			}
+			// 其余的调用已经被 receive 取走，等它们写完结果
+			for ; remaining > 0; remaining-- {
+				<-done
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// 登记并写出所有请求，最后统一 Flush
+func (client *Client) sendBatch(calls []*Call) {
+	client.sending.Lock()
+	defer client.sending.Unlock()
+
+	bw, buffered := client.cc.(codec.BufferedWriter)
+	for _, call := range calls {
+		if _, err := client.registerCall(call); err != nil {
+			call.Error = err
+			call.done()
+			continue
+		}
+		header := requestHeader(call)
+		body, err := client.signRequest(&header, call.Args)
+		switch {
+		case err != nil:
+		case buffered:
+			err = bw.WriteBuffered(&header, body)
+		default:
+			err = client.cc.Write(&header, body)
+		}
+		if err != nil && client.removePendingCall(call) {
+			call.Error = err
+			call.done()
+		}
+	}
+	if !buffered {
+		return
+	}
+	if err := bw.Flush(); err != nil {
+		// 连接已经关闭，已写入缓冲区的请求都没有发出去
+		for _, call := range calls {
+			if client.removePendingCall(call) {
+				call.Error = err
+				call.done()
+			}
+		}
+	}
+}
+
+// call 仍在 pending 中时将其移除并返回 true
+// 与 removeCall 不同，按 call 而不是 seq 判断，没有登记成功的 call 的 Seq 不可信
+func (client *Client) removePendingCall(call *Call) bool {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if client.pending[call.Seq] != call {
+		return false
+	}
+	delete(client.pending, call.Seq)
+	clientPending.WithLabelValues().Dec()
+	return true
+}
@@ -20,7 +20,11 @@ package myrpc
 
 import (
 	"MyRPC/codec"
+	"MyRPC/metadata"
+	"MyRPC/status"
+	"MyRPC/trace"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -39,9 +43,22 @@ type Call struct {
 	Reply         interface{}
 	Error         error
 	Done          chan *Call // 用于接受 receive 拿到的返回
+
+	Metadata         metadata.MD // 随请求发送的元数据
+	ResponseMetadata metadata.MD // 服务端随响应返回的元数据，在 Done 之后可读
+
+	deadline time.Time     // 调用方 ctx 的截止时间，发送时换算成剩余时间告知服务端
+	stream   *ClientStream // 流式调用时非空，结果交给 stream 而不是 Done
+	typ      codec.MsgType // 请求帧的类型，打开双向流时为 MsgStreamOpen
+	start    time.Time     // 开始发送的时间，用于统计耗时；为零说明没有经过 send（如 Go 在拦截器外层的 call），不统计
 }
 
 func (call *Call) done() {
+	observeClientCall(call)
+	if call.stream != nil {
+		call.stream.finish(call.Error)
+		return
+	}
 	call.Done <- call
 }
 
@@ -55,11 +72,16 @@ type Client struct {
 	pending  map[uint64]*Call // 存储未处理完的请求，key 为编号
 	closing  bool             // user 调用了 Close 方法
 	shutdown bool             // 置为 true 时表示有错误发生
+	draining bool             // 服务端发来了 GoAway，不再发起新的调用，已发出的调用继续等待响应
+	lost     chan struct{}    // receive 退出（连接断开或被关闭）时关闭，见 reconnect.go
+	goAway   chan struct{}    // 收到 GoAway 时关闭
+
+	interceptors []ClientInterceptor
 }
 
 var _ io.Closer = (*Client)(nil) // 通过指向 Client 类型的空指针进行接口实现检查
 
-var ErrShutDown = errors.New("connection is shut down")
+var ErrShutDown = status.New(status.Unavailable, "connection is shut down")
 
 // 实现 io.Closer 接口的 close() 方法
 // io.Closer 是一个接口，只定义了方法是签名，不提供任何实现
@@ -74,30 +96,65 @@ func (client *Client) Close() error {
 	return err
 }
 
+// 返回的 channel 在连接断开或 Client 被关闭、所有 pending 的调用都已结束时关闭
+func (client *Client) Disconnected() <-chan struct{} {
+	return client.lost
+}
+
+// 返回的 channel 在收到服务端的 GoAway 时关闭，之后这个 Client 不再接受新的调用
+func (client *Client) GoAway() <-chan struct{} {
+	return client.goAway
+}
+
+const retireTimeout = time.Minute
+
+// 不再使用的 Client（收到 GoAway、被替换或移除）调用 Retire，不阻塞
+// 等到连接断开、已经发出的调用都结束之后再关闭，最多等待 retireTimeout
+func (client *Client) Retire() {
+	go func() {
+		select {
+		case <-client.lost:
+		case <-time.After(retireTimeout):
+		}
+		_ = client.Close()
+	}()
+}
+
 func (client *Client) IsAvailable() bool {
 	client.mu.Lock()
 	defer client.mu.Unlock()
-	return !client.shutdown && !client.closing
+	return !client.shutdown && !client.closing && !client.draining
 }
 
 // 将请求添加到 client.pending 中
 func (client *Client) registerCall(call *Call) (uint64, error) {
 	client.mu.Lock()
 	defer client.mu.Unlock()
-	if client.closing || client.shutdown {
+	call.start = time.Now()
+	if client.closing || client.shutdown || client.draining {
 		return 0, ErrShutDown
 	}
 	call.Seq = client.seq
 	client.pending[call.Seq] = call
 	client.seq++
+	clientPending.WithLabelValues().Inc()
 	return call.Seq, nil
 }
 
+func (client *Client) getCall(seq uint64) *Call {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return client.pending[seq]
+}
+
 func (client *Client) removeCall(seq uint64) *Call {
 	client.mu.Lock()
 	defer client.mu.Unlock()
 	call := client.pending[seq]
-	delete(client.pending, seq)
+	if call != nil {
+		delete(client.pending, seq)
+		clientPending.WithLabelValues().Dec()
+	}
 	return call
 }
 
@@ -108,7 +165,9 @@ func (client *Client) terminateCalls(err error) {
 	client.mu.Lock()
 	defer client.mu.Unlock() // FIFO，先执行
 	client.shutdown = true
-	for _, call := range client.pending {
+	for seq, call := range client.pending {
+		delete(client.pending, seq)
+		clientPending.WithLabelValues().Dec()
 		call.Error = err
 		call.done()
 	}
@@ -123,23 +182,61 @@ func (client *Client) receive() {
 		if err = client.cc.ReadHeader(&H); err != nil {
 			break
 		}
+		if H.Type == codec.MsgGoAway {
+			client.mu.Lock()
+			if !client.draining {
+				client.draining = true
+				close(client.goAway)
+			}
+			client.mu.Unlock()
+			err = client.cc.ReadBody(nil)
+			continue
+		}
+		// 流式调用的数据帧，call 保留在 pending 中直到收到结束帧
+		if H.Type == codec.MsgStreamData {
+			err = client.receiveStreamData(&H)
+			continue
+		}
+		if H.Type == codec.MsgWindowUpdate {
+			client.receiveWindowUpdate(&H)
+			err = client.cc.ReadBody(nil)
+			continue
+		}
 		call := client.removeCall(H.Seq)
+		if call != nil {
+			call.ResponseMetadata = H.Metadata
+		}
 		switch {
 		case call == nil:
 			err = client.cc.ReadBody(nil)
 		case H.Error != "":
-			call.Error = fmt.Errorf("%s", H.Error)
+			call.Error = headerError(&H)
 			err = client.cc.ReadBody(nil)
 			call.done()
 		default:
 			err = client.cc.ReadBody(call.Reply)
 			if err != nil {
-				call.Error = errors.New("reading body " + err.Error())
+				call.Error = status.Errorf(status.Internal, "reading body %v", err)
 			}
 			call.done() // 通知异步调用方已处理完调用返回值
 		}
+		// 分帧的 Codec 已经跳过了这条消息，只影响当前的 call，连接可以继续使用
+		if errors.Is(err, codec.ErrBadBody) {
+			err = nil
+		}
 	}
 	client.terminateCalls(err)
+	clientConns.WithLabelValues().Dec()
+	close(client.lost)
+}
+
+// 响应头中的错误信息还原为 *status.Error，老版本的服务端没有错误码时为 Unknown
+func headerError(h *codec.Header) error {
+	code := status.Code(h.Code)
+	if code == status.OK {
+		code = status.Unknown
+	}
+	return &status.Error{Code: code, Message: h.Error, Details: h.Details}
 }
 
 // 返回 client 实例的同时，启动 receive() 方法
@@ -147,18 +244,21 @@ func NewClient(conn net.Conn, opt *Option) (*Client, error) {
 	f := codec.NewCodecFuncMap[opt.CodecType]
 	if f == nil {
 		err := fmt.Errorf("undefined codec type")
-		log.Println("rpc client: option undefined error: ", err)
+		opt.logger().Error("rpc client: undefined codec type", "codec", opt.CodecType)
 		_ = conn.Close()
 		return nil, err
 	}
 
 	err := json.NewEncoder(conn).Encode(opt)
 	if err != nil {
-		log.Println("rpc client: option decode error:", err)
+		opt.logger().Error("rpc client: option encode error", "remote_addr", conn.RemoteAddr().String(), "err", err)
 		_ = conn.Close()
 		return nil, err
 	}
-	return newClientCodec(f(conn), opt), nil
+	// 握手之后的流量按 Codec 统计
+	codecType := string(opt.CodecType)
+	mc := newMeteredConn(conn, clientBytesIn.WithLabelValues(codecType), clientBytesOut.WithLabelValues(codecType))
+	return newClientCodec(f(mc), opt), nil
 }
 
 func newClientCodec(cc codec.Codec, opt *Option) *Client {
@@ -167,7 +267,10 @@ func newClientCodec(cc codec.Codec, opt *Option) *Client {
 		cc:      cc,
 		opt:     opt,
 		pending: make(map[uint64]*Call),
+		lost:    make(chan struct{}),
+		goAway:  make(chan struct{}),
 	}
+	clientConns.WithLabelValues().Inc()
 	go client.receive()
 	return client // 协程的启动不会因为函数的返回而终止，而是在后台执行
 }
@@ -179,7 +282,9 @@ func parseOptions(opts ...*Option) (*Option, error) { // 可变参数，函数
 	if len(opts) != 1 {
 		return nil, errors.New("number of option > 1")
 	}
-	opt := opts[0]
+	// 复制一份再补全，同一个 Option 可能被多个协程同时用来拨号（如 ReconnectingClient、XClient）
+	o := *opts[0]
+	opt := &o
 	opt.MagicNumber = DefaultOption.MagicNumber
 	if opt.CodecType == "" {
 		opt.CodecType = DefaultOption.CodecType
@@ -200,6 +305,11 @@ func dialWithTimeout(f newClientFunc, network, address string, opts ...*Option)
 	if err != nil {
 		return nil, err
 	}
+	defer func() {
+		if err != nil {
+			clientDialFailures.WithLabelValues().Inc()
+		}
+	}()
 
 	/*
 		使用 net 包的 DialTimeout 方法，获取连接 net.Conn
@@ -209,7 +319,13 @@ func dialWithTimeout(f newClientFunc, network, address string, opts ...*Option)
 		返回一个字节流/数据报的原始连接，如果需要支持应用层协议，如 HTTP 协议可以使用 net/http 库处理
 		阻塞直到连接成功
 	*/
-	conn, err := net.DialTimeout(network, address, opt.ConnectionTimeout)
+	var conn net.Conn
+	if opt.TLSConfig != nil {
+		// 握手在 Dial 内完成，ServerName 为空时按 address 校验服务端证书
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: opt.ConnectionTimeout}, network, address, opt.TLSConfig)
+	} else {
+		conn, err = net.DialTimeout(network, address, opt.ConnectionTimeout)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -261,12 +377,11 @@ func (client *Client) send(call *Call) {
 
 	// if err := client.cc.Write(&client.header, call.Args); err != nil {
 
-	header := codec.Header{
-		ServiceMethod: call.ServiceMethod,
-		Seq:           seq,
-		Error:         "",
+	header := requestHeader(call)
+	body, err := client.signRequest(&header, call.Args)
+	if err == nil {
+		err = client.cc.Write(&header, body)
 	}
-	err = client.cc.Write(&header, call.Args)
 	if err != nil { // 这里的 Write 要防止数据竞争
 		call := client.removeCall(seq)
 		if call != nil {
@@ -276,34 +391,151 @@ func (client *Client) send(call *Call) {
 	}
 }
 
+// 构造 call 的请求头，call.Seq 需要已经登记
+func requestHeader(call *Call) codec.Header {
+	header := codec.Header{
+		ServiceMethod: call.ServiceMethod,
+		Seq:           call.Seq,
+		Error:         "",
+		Metadata:      call.Metadata,
+		Type:          call.typ,
+	}
+	if !call.deadline.IsZero() {
+		header.Timeout = time.Until(call.deadline)
+		if header.Timeout <= 0 { // 已经超时，Call 中的 ctx.Done() 会负责收尾
+			header.Timeout = time.Nanosecond
+		}
+	}
+	return header
+}
+
+// 通知服务端放弃 seq 对应的请求，服务端会取消服务方法的 ctx 并且不再写响应
+func (client *Client) sendCancel(seq uint64) {
+	client.sending.Lock()
+	defer client.sending.Unlock()
+	if !client.IsAvailable() {
+		return
+	}
+	header := codec.Header{
+		Seq:  seq,
+		Type: codec.MsgCancel,
+	}
+	if err := client.cc.Write(&header, struct{}{}); err != nil {
+		client.opt.logger().Warn("rpc client: send cancel error", "seq", seq, "err", err)
+	}
+}
+
 // 暴露给框架使用者的接口
 // 同步和异步的区别：监听 Call.Done 这个 channel 的工作是交给框架的 client 来做还是交给用户自己做
 
 // 异步：传入一个 channel，在 send 之后直接返回，等 receive() 协程异步写入 call 的 Reply
+// 需要携带元数据时，可以自行构造 Call 并设置 Metadata 字段后调用 GoCall
+// 设置了拦截器时，会在后台协程中经过拦截器链发起调用，不保证返回时请求已经发出
 func (client *Client) Go(serviceMethod string, args, reply interface{}, done chan *Call) *Call {
-	if done == nil {
-		done = make(chan *Call, 10) // 允许在没有立即消费的情况下存储一定数量的值
-	} else if cap(done) == 0 {
-		log.Panic("rpc client: done channel is unbuffered")
-	}
 	call := &Call{
 		ServiceMethod: serviceMethod,
 		Args:          args,
 		Reply:         reply,
 		Done:          done,
 	}
+	interceptors := client.getInterceptors()
+	if len(interceptors) == 0 {
+		return client.GoCall(call)
+	}
+	call.Done = checkDone(call.Done)
+	invoker := ChainClientInterceptors(interceptors, client.invoke)
+	go func() {
+		call.Error = invoker(context.Background(), serviceMethod, args, reply)
+		call.done()
+	}()
+	return call
+}
+
+// 不经过拦截器，直接发送 call
+func (client *Client) GoCall(call *Call) *Call {
+	call.Done = checkDone(call.Done)
 	client.send(call)
 	return call
 }
 
+func checkDone(done chan *Call) chan *Call {
+	if done == nil {
+		done = make(chan *Call, 10) // 允许在没有立即消费的情况下存储一定数量的值
+	} else if cap(done) == 0 {
+		log.Panic("rpc client: done channel is unbuffered")
+	}
+	return done
+}
+
+// 同步调用时的可选配置
+type CallOption func(*callOptions)
+
+type callOptions struct {
+	responseMetadata *metadata.MD
+}
+
+// 调用结束后将服务端返回的元数据写入 md
+func WithResponseMetadata(md *metadata.MD) CallOption {
+	return func(o *callOptions) {
+		o.responseMetadata = md
+	}
+}
+
 // 同步
-func (client *Client) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
-	call := client.Go(serviceMethod, args, reply, make(chan *Call, 1))
+// ctx 的截止时间会随请求发送给服务端，ctx 被取消时会通知服务端放弃这个请求
+// ctx 中通过 metadata.NewOutgoingContext 设置的元数据会随请求一起发送
+// 通过 Use 设置的拦截器按注册顺序依次执行
+// 每次调用创建一个 client span（包括拦截器），traceparent 随请求头发送，见 trace 包
+func (client *Client) Call(ctx context.Context, serviceMethod string, args, reply interface{}, opts ...CallOption) (err error) {
+	ctx, span := trace.Start(ctx, serviceMethod, trace.KindClient)
+	defer func() { span.Finish(err) }()
+
+	interceptors := client.getInterceptors()
+	if len(interceptors) == 0 {
+		return client.invoke(ctx, serviceMethod, args, reply, opts...)
+	}
+	return ChainClientInterceptors(interceptors, client.invoke)(ctx, serviceMethod, args, reply, opts...)
+}
+
+// 随请求发送的元数据：ctx 中的 outgoing 元数据，加上当前 span 的 traceparent
+func outgoingMetadata(ctx context.Context) metadata.MD {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	return trace.Inject(ctx, md)
+}
+
+// 拦截器链的末端，真正发送请求并等待响应
+func (client *Client) invoke(ctx context.Context, serviceMethod string, args, reply interface{}, opts ...CallOption) error {
+	var o callOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	md, err := client.requestMetadata(ctx, serviceMethod, args)
+	if err != nil {
+		return err
+	}
+	deadline, _ := ctx.Deadline()
+	call := client.GoCall(&Call{
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		Reply:         reply,
+		Done:          make(chan *Call, 1),
+		Metadata:      md,
+		deadline:      deadline,
+	})
 	select {
 	case <-ctx.Done():
-		client.removeCall(call.Seq)
-		return errors.New("client: Call timeout" + ctx.Err().Error())
+		// 仍在 pending 中说明服务端还没有响应，通知服务端不必继续处理
+		err := status.Errorf(status.Convert(ctx.Err()).Code, "client: call %s: %v", serviceMethod, ctx.Err())
+		if client.removeCall(call.Seq) != nil {
+			client.sendCancel(call.Seq)
+			call.Error = err
+			call.done()
+		}
+		return err
 	case result := <-call.Done:
+		if o.responseMetadata != nil {
+			*o.responseMetadata = result.ResponseMetadata
+		}
 		return result.Error
 	}
 }
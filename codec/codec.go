@@ -1,30 +1,82 @@
 package codec // 消息编码解码相关
 
 import (
+	"errors"
 	"io"
+	"time"
 )
 
 type Header struct {
-	ServiceMethod string // format : "Service.Method"
-	Seq uint64 // 客户端请求序列号
-	Error string
+	ServiceMethod string            // format : "Service.Method"
+	Seq           uint64            // 客户端请求序列号
+	Error         string            // 错误信息，非空表示调用失败
+	Code          uint32            // 错误码，取值见 status 包
+	Details       map[string]string // 错误的附加信息
+	Metadata      map[string]string // 请求 / 响应的元数据，如鉴权 token、trace id 等，见 metadata 包
+	Type          MsgType           // 消息类型，零值为普通的请求 / 响应
+	Timeout       time.Duration     // 客户端剩余的超时时间，单位纳秒，0 表示不限制
+	Window        uint32            // MsgWindowUpdate 归还的发送额度，单位为消息条数
+	RawBody       bool              // body 以 RawBody 写出，接收方需要用 RawBodyCodec 读取，见 RawBodyCodec
 }
 
+type MsgType uint8
+
+const (
+	MsgCall            MsgType = iota // 普通的请求 / 响应
+	MsgCancel                         // 客户端放弃了 Seq 对应的请求，body 为空
+	MsgGoAway                         // 服务端即将关闭，客户端不要在这个连接上发起新的请求，body 为空
+	MsgStreamData                     // 流式调用中的一条消息
+	MsgStreamEnd                      // 流式调用结束，Error 非空表示出错，body 为空
+	MsgStreamOpen                     // 打开双向流，body 为第一条消息
+	MsgStreamHalfClose                // 客户端不再发送消息，body 为空
+	MsgWindowUpdate                   // 接收方归还 Window 条发送额度，body 为空
+	MsgOneWay                         // 单向调用，服务端处理后不发送响应
+)
+
 // 抽象出 接口是为了实现不同的 Codec 实例
 type Codec interface { // 接口只关心方法是否被实现，允许实现类自定义结构体字段
-	io.Closer						
+	io.Closer
 	ReadHeader(*Header) error
 	ReadBody(interface{}) error
 	Write(*Header, interface{}) error
 }
 
+// 可选接口：WriteBuffered 只把消息写入缓冲区，Flush 时才一起写到连接上
+// 一次写出多条消息时使用（如 Client.Batch），没有实现这个接口的 Codec 逐条 Write
+// 与 Write 一样，写连接出错时会关闭连接
+type BufferedWriter interface {
+	WriteBuffered(*Header, interface{}) error
+	Flush() error
+}
+
+// 已经编码好的 body，Write / WriteBuffered 把它原样写出，不再编码
+// 由 RawBodyCodec.MarshalBody 得到，发送时需要同时把 Header.RawBody 置为 true
+type RawBody []byte
+
+// 可选接口：以编码后的字节读写 body，两端看到的是同样的字节，用于对 body 签名
+// 发送方：MarshalBody 编码，再以 RawBody 的形式 Write
+// 接收方：Header.RawBody 为 true 时用 ReadRawBody 代替 ReadBody 读出字节，再用 UnmarshalBody 解码
+type RawBodyCodec interface {
+	MarshalBody(body interface{}) (RawBody, error)
+	ReadRawBody() (RawBody, error)
+	UnmarshalBody(data RawBody, body interface{}) error // 解码失败时返回 ErrBadBody，连接不受影响
+}
+
+// 消息体解码失败，但这条消息已经被完整读出，连接上的后续消息不受影响
+// 只有分帧的 Codec 会返回这个错误，调用方可以据此判断连接是否还能继续使用
+var ErrBadBody = errors.New("codec: bad message body")
+
 type NewCodecFunc func(io.ReadWriteCloser) Codec // 定义一个函数类型；这是 Codec 的构造函数
 
 type Type string
 
 const (
-	GobType Type = "application/gob"
-	JsonType Type = "application/json" // 尚未实现
+	GobType  Type = "application/gob"
+	JsonType Type = "application/json"
+
+	// 分帧格式，每条消息带有长度前缀，body 解码失败时可以直接跳过
+	GobFrameType  Type = "application/gob+frame"
+	JsonFrameType Type = "application/json+frame"
 )
 
 var NewCodecFuncMap map[Type]NewCodecFunc
@@ -32,5 +84,7 @@ var NewCodecFuncMap map[Type]NewCodecFunc
 func init() {
 	NewCodecFuncMap = make(map[Type]NewCodecFunc)
 	NewCodecFuncMap[GobType] = NewGobCodec
+	NewCodecFuncMap[JsonType] = NewJsonCodec
+	NewCodecFuncMap[GobFrameType] = NewGobFrameCodec
+	NewCodecFuncMap[JsonFrameType] = NewJsonFrameCodec
 }
-
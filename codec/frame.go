@@ -0,0 +1,186 @@
+/*
+分帧的 Codec 实现
+
+GobCodec / JsonCodec 直接把 header 和 body 作为两个连续的值写入流中，
+一旦某个 body 解码失败，解码器就停在了流的中间，整个连接无法再使用。
+FrameCodec 为每条消息加上长度前缀，读取时总是先把整帧读出来再解码，
+body 解码失败只影响这一条消息。
+
+| totalLen uint32 | headerLen uint32 | bodyLen uint32 | header | body |
+totalLen = 8 + headerLen + bodyLen，即 totalLen 字段之后的全部字节数，大端序
+
+header 和 body 各自独立编码，gob 编码时每条消息都会带上完整的类型信息
+*/
+
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+const (
+	frameLenSize = 4
+	framePrefix  = 3 * frameLenSize
+	MaxFrameSize = 64 << 20 // 单帧上限，防止对端发送错误的长度导致分配过大的内存
+)
+
+type FrameCodec struct {
+	conn      io.ReadWriteCloser
+	r         *bufio.Reader
+	buf       *bufio.Writer
+	marshal   func(interface{}) ([]byte, error)
+	unmarshal func([]byte, interface{}) error
+	body      []byte // ReadHeader 读出的当前帧的 body，等待 ReadBody 取走
+}
+
+var _ Codec = (*FrameCodec)(nil)
+var _ BufferedWriter = (*FrameCodec)(nil)
+var _ RawBodyCodec = (*FrameCodec)(nil)
+
+func NewGobFrameCodec(conn io.ReadWriteCloser) Codec {
+	return newFrameCodec(conn, gobMarshal, gobUnmarshal)
+}
+
+func NewJsonFrameCodec(conn io.ReadWriteCloser) Codec {
+	return newFrameCodec(conn, json.Marshal, json.Unmarshal)
+}
+
+func newFrameCodec(conn io.ReadWriteCloser, marshal func(interface{}) ([]byte, error), unmarshal func([]byte, interface{}) error) *FrameCodec {
+	return &FrameCodec{
+		conn:      conn,
+		r:         bufio.NewReader(conn),
+		buf:       bufio.NewWriter(conn),
+		marshal:   marshal,
+		unmarshal: unmarshal,
+	}
+}
+
+func gobMarshal(v interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(v); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func gobUnmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// 读出一整帧，解码 header，body 暂存到 c.body 中
+func (c *FrameCodec) ReadHeader(h *Header) error {
+	c.body = nil
+	var prefix [framePrefix]byte
+	if _, err := io.ReadFull(c.r, prefix[:]); err != nil {
+		return err
+	}
+	total := binary.BigEndian.Uint32(prefix[0:])
+	headerLen := binary.BigEndian.Uint32(prefix[4:])
+	bodyLen := binary.BigEndian.Uint32(prefix[8:])
+	if total > MaxFrameSize || uint64(total) != 2*frameLenSize+uint64(headerLen)+uint64(bodyLen) {
+		return fmt.Errorf("codec: invalid frame length total=%d header=%d body=%d", total, headerLen, bodyLen)
+	}
+
+	data := make([]byte, headerLen+bodyLen)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return err
+	}
+	if err := c.unmarshal(data[:headerLen], h); err != nil {
+		return fmt.Errorf("codec: decode frame header: %w", err)
+	}
+	c.body = data[headerLen:]
+	return nil
+}
+
+// body 为 nil 时直接丢弃当前帧的 body
+func (c *FrameCodec) ReadBody(body interface{}) error {
+	data := c.body
+	c.body = nil
+	if body == nil {
+		return nil
+	}
+	return c.UnmarshalBody(data, body)
+}
+
+func (c *FrameCodec) MarshalBody(body interface{}) (RawBody, error) {
+	return c.marshal(body)
+}
+
+// 帧中的 body 本身就是独立编码的
+func (c *FrameCodec) ReadRawBody() (RawBody, error) {
+	data := c.body
+	c.body = nil
+	return data, nil
+}
+
+func (c *FrameCodec) UnmarshalBody(data RawBody, body interface{}) error {
+	if err := c.unmarshal(data, body); err != nil {
+		return fmt.Errorf("%w: %v", ErrBadBody, err)
+	}
+	return nil
+}
+
+// 编码失败时什么都不会写入连接，不影响后续消息；只有写连接失败才关闭连接
+func (c *FrameCodec) Write(h *Header, body interface{}) error {
+	if err := c.WriteBuffered(h, body); err != nil {
+		return err
+	}
+	return c.Flush()
+}
+
+// 只写入 buf，不 Flush；编码失败时什么都不写，连接可以继续使用
+func (c *FrameCodec) WriteBuffered(h *Header, body interface{}) (err error) {
+	header, err := c.marshal(h)
+	if err != nil {
+		slog.Error("codec: frame error encoding header", "err", err)
+		return err
+	}
+	data, isRaw := body.(RawBody)
+	if !isRaw {
+		if data, err = c.marshal(body); err != nil {
+			slog.Error("codec: frame error encoding body", "err", err)
+			return err
+		}
+	}
+	total := 2*frameLenSize + len(header) + len(data)
+	if total > MaxFrameSize {
+		return fmt.Errorf("codec: frame too large: %d", total)
+	}
+
+	defer func() {
+		if err != nil {
+			_ = c.Close()
+		}
+	}()
+	var prefix [framePrefix]byte
+	binary.BigEndian.PutUint32(prefix[0:], uint32(total))
+	binary.BigEndian.PutUint32(prefix[4:], uint32(len(header)))
+	binary.BigEndian.PutUint32(prefix[8:], uint32(len(data)))
+	if _, err = c.buf.Write(prefix[:]); err != nil {
+		return err
+	}
+	if _, err = c.buf.Write(header); err != nil {
+		return err
+	}
+	_, err = c.buf.Write(data)
+	return err
+}
+
+func (c *FrameCodec) Flush() error {
+	if err := c.buf.Flush(); err != nil {
+		_ = c.Close()
+		return err
+	}
+	return nil
+}
+
+func (c *FrameCodec) Close() error {
+	return c.conn.Close()
+}
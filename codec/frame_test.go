@@ -0,0 +1,89 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"io"
+	"testing"
+)
+
+// 用内存缓冲区代替连接
+type bufConn struct {
+	bytes.Buffer
+}
+
+func (c *bufConn) Close() error { return nil }
+
+type frameArgs struct {
+	A int
+}
+
+func TestFrameBadBody(t *testing.T) {
+	for ct, f := range map[Type]NewCodecFunc{GobFrameType: NewGobFrameCodec, JsonFrameType: NewJsonFrameCodec} {
+		conn := &bufConn{}
+		cc := f(conn)
+		for seq, body := range []interface{}{"not args", frameArgs{A: 1}, frameArgs{A: 2}} {
+			if err := cc.Write(&Header{ServiceMethod: "Foo.Sum", Seq: uint64(seq)}, body); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var h Header
+		var args frameArgs
+		if err := cc.ReadHeader(&h); err != nil {
+			t.Fatal(err)
+		}
+		if err := cc.ReadBody(&args); !errors.Is(err, ErrBadBody) {
+			t.Fatalf("%s: ReadBody = %v, want ErrBadBody", ct, err)
+		}
+		// 坏掉的 body 不影响之后的消息，包括被丢弃的 body
+		if err := cc.ReadHeader(&h); err != nil || h.Seq != 1 {
+			t.Fatalf("%s: ReadHeader = %+v, %v", ct, h, err)
+		}
+		if err := cc.ReadBody(nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := cc.ReadHeader(&h); err != nil || h.Seq != 2 {
+			t.Fatalf("%s: ReadHeader = %+v, %v", ct, h, err)
+		}
+		if err := cc.ReadBody(&args); err != nil || args.A != 2 {
+			t.Fatalf("%s: ReadBody = %+v, %v", ct, args, err)
+		}
+		if err := cc.ReadHeader(&h); err != io.EOF {
+			t.Fatalf("%s: ReadHeader at the end = %v, want io.EOF", ct, err)
+		}
+	}
+}
+
+func TestFrameInvalidLength(t *testing.T) {
+	conn := &bufConn{}
+	_, _ = conn.Write([]byte{0, 0, 0, 100, 0, 0, 0, 1, 0, 0, 0, 1})
+	var h Header
+	if err := NewGobFrameCodec(conn).ReadHeader(&h); err == nil {
+		t.Fatal("ReadHeader accepted inconsistent frame lengths")
+	}
+}
+
+// GobCodec 的格式与分帧无关：header 和 body 是流上两个连续的 gob 值，普通的 gob.Decoder 可以直接读
+func TestGobCodecWire(t *testing.T) {
+	conn := &bufConn{}
+	cc := NewGobCodec(conn)
+	for seq := uint64(1); seq <= 2; seq++ {
+		if err := cc.Write(&Header{ServiceMethod: "Foo.Sum", Seq: seq}, frameArgs{A: int(seq)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dec := gob.NewDecoder(bytes.NewReader(conn.Bytes()))
+	for seq := uint64(1); seq <= 2; seq++ {
+		var h Header
+		var args frameArgs
+		if err := dec.Decode(&h); err != nil || h.Seq != seq || h.ServiceMethod != "Foo.Sum" {
+			t.Fatalf("header = %+v, %v", h, err)
+		}
+		if err := dec.Decode(&args); err != nil || args.A != int(seq) {
+			t.Fatalf("body = %+v, %v", args, err)
+		}
+	}
+}
@@ -9,8 +9,9 @@ package codec
 import (
 	"bufio"
 	"encoding/gob" // 专门用于将 go 的结构体 / 切片 / Map 等转换为二进制形式（序列化）；以及将二进制形式的数据解码成 go 数据结构（反序列化）
+	"fmt"
 	"io"
-	"log"
+	"log/slog"
 )
 
 type GobCodec struct {
@@ -21,6 +22,8 @@ type GobCodec struct {
 }
 
 var _ Codec = (*GobCodec)(nil)
+var _ BufferedWriter = (*GobCodec)(nil)
+var _ RawBodyCodec = (*GobCodec)(nil)
 
 func NewGobCodec(conn io.ReadWriteCloser) Codec {
 	buf := bufio.NewWriter(conn)
@@ -42,23 +45,59 @@ func (c *GobCodec) ReadBody(body interface{}) error {
 	return c.dec.Decode(body)
 }
 
-func (c *GobCodec) Write(h *Header, body interface{}) (err error) {
+func (c *GobCodec) Write(h *Header, body interface{}) error {
+	if err := c.WriteBuffered(h, body); err != nil {
+		return err
+	}
+	return c.Flush()
+}
+
+// 只编码到 buf，不 Flush；编码出错时 buf 中可能已经有了半条消息，只能关闭连接
+func (c *GobCodec) WriteBuffered(h *Header, body interface{}) (err error) {
 	defer func() {
-		_ = c.buf.Flush()
 		if err != nil {
 			_ = c.Close()
 		}
 	}()
-	
+
 	// 将 header 和 body 编码成二进制数据先后写入 buf 只能够
 	if err = c.enc.Encode(h); err != nil {
-		log.Println("codec: gob error encoding header:", err)
+		slog.Error("codec: gob error encoding header", "err", err)
+		return err
 	}
 
 	if err = c.enc.Encode(body); err != nil {
-		log.Println("codec: gob error encoding body:", err)
+		slog.Error("codec: gob error encoding body", "err", err)
+		return err
+	}
+
+	return nil
+}
+
+// 流中的 gob 编码依赖之前已经发送过的类型信息，单独取出的字节无法解码，
+// 因此 RawBody 使用与 GobFrameCodec 相同的独立编码，在流中作为一个 []byte 值传输
+func (c *GobCodec) MarshalBody(body interface{}) (RawBody, error) {
+	return gobMarshal(body)
+}
+
+func (c *GobCodec) ReadRawBody() (RawBody, error) {
+	var data []byte
+	err := c.dec.Decode(&data)
+	return data, err
+}
+
+func (c *GobCodec) UnmarshalBody(data RawBody, body interface{}) error {
+	if err := gobUnmarshal(data, body); err != nil {
+		return fmt.Errorf("%w: %v", ErrBadBody, err)
 	}
+	return nil
+}
 
+func (c *GobCodec) Flush() error {
+	if err := c.buf.Flush(); err != nil {
+		_ = c.Close()
+		return err
+	}
 	return nil
 }
 
@@ -0,0 +1,114 @@
+/*
+Codec 的另一个实现类，使用 json 编码，方便非 Go 语言的调用方接入
+
+与 GobCodec 一样，header 和 body 作为两个连续的 json 值写入同一个流
+*/
+
+package codec
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+type JsonCodec struct {
+	conn io.ReadWriteCloser
+	buf  *bufio.Writer
+	dec  *json.Decoder
+	enc  *json.Encoder
+}
+
+var _ Codec = (*JsonCodec)(nil)
+var _ BufferedWriter = (*JsonCodec)(nil)
+var _ RawBodyCodec = (*JsonCodec)(nil)
+
+func NewJsonCodec(conn io.ReadWriteCloser) Codec {
+	buf := bufio.NewWriter(conn)
+	return &JsonCodec{
+		conn: conn,
+		buf:  buf,
+		dec:  json.NewDecoder(conn),
+		enc:  json.NewEncoder(buf),
+	}
+}
+
+func (c *JsonCodec) ReadHeader(h *Header) error {
+	return c.dec.Decode(h)
+}
+
+// body 为 nil 时需要把这个 json 值读出来丢掉，否则下一次 ReadHeader 会读到 body
+func (c *JsonCodec) ReadBody(body interface{}) error {
+	if body == nil {
+		var discard json.RawMessage
+		return c.dec.Decode(&discard)
+	}
+	return c.dec.Decode(body)
+}
+
+func (c *JsonCodec) Write(h *Header, body interface{}) error {
+	if err := c.WriteBuffered(h, body); err != nil {
+		return err
+	}
+	return c.Flush()
+}
+
+// 只编码到 buf，不 Flush；编码出错时连接上可能已经写入了半条消息，只能关闭
+func (c *JsonCodec) WriteBuffered(h *Header, body interface{}) (err error) {
+	defer func() {
+		if err != nil {
+			_ = c.Close()
+		}
+	}()
+
+	if err = c.enc.Encode(h); err != nil {
+		slog.Error("codec: json error encoding header", "err", err)
+		return err
+	}
+
+	if raw, ok := body.(RawBody); ok {
+		// 与 Encode 一样以换行结尾
+		if _, err = c.buf.Write(raw); err == nil {
+			err = c.buf.WriteByte('\n')
+		}
+		return err
+	}
+	if err = c.enc.Encode(body); err != nil {
+		slog.Error("codec: json error encoding body", "err", err)
+		return err
+	}
+
+	return nil
+}
+
+func (c *JsonCodec) MarshalBody(body interface{}) (RawBody, error) {
+	return json.Marshal(body)
+}
+
+// json.RawMessage 保留 body 在流中的原始字节
+func (c *JsonCodec) ReadRawBody() (RawBody, error) {
+	var raw json.RawMessage
+	err := c.dec.Decode(&raw)
+	return RawBody(raw), err
+}
+
+func (c *JsonCodec) UnmarshalBody(data RawBody, body interface{}) error {
+	if err := json.Unmarshal(data, body); err != nil {
+		return fmt.Errorf("%w: %v", ErrBadBody, err)
+	}
+	return nil
+}
+
+func (c *JsonCodec) Flush() error {
+	if err := c.buf.Flush(); err != nil {
+		_ = c.Close()
+		return err
+	}
+	return nil
+}
+
+func (c *JsonCodec) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,143 @@
+/*
+
+调试页面：HandleHTTP 同时在 defaultDebugPath 上注册，列出所有服务和方法的统计信息
+
+	GET /debug/myrpc               HTML，给人看
+	GET /debug/myrpc?format=json   JSON，给脚本用（请求头 Accept: application/json 效果相同）
+
+内置服务（如 _Reflection）排在用户服务之后，并标记为 built-in
+
+耗时分位数基于每个方法最近 latencySamples 次调用，见 service.go
+
+*/
+
+package myrpc
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const debugText = `<html>
+	<head><title>MyRPC Services</title></head>
+	<body>
+	<p>Connections: {{.Connections}} &nbsp; In-flight requests: {{.InFlight}}</p>
+	{{range .Services}}
+	<hr>
+	Service {{.Name}}{{if .Builtin}} (built-in){{end}}
+	<hr>
+		<table>
+		<th align=center>Method</th><th align=center>Calls</th><th align=center>Errors</th><th align=center>p50</th><th align=center>p90</th><th align=center>p99</th>
+		{{range .Methods}}
+			<tr>
+			<td align=left font=fixed>{{.Name}}({{.ArgType}}, {{.ReplyType}}) error{{if .Stream}} [stream]{{end}}</td>
+			<td align=center>{{.Calls}}</td>
+			<td align=center>{{.Errors}}</td>
+			<td align=center>{{.LatencyP50}}</td>
+			<td align=center>{{.LatencyP90}}</td>
+			<td align=center>{{.LatencyP99}}</td>
+			</tr>
+		{{end}}
+		</table>
+	{{end}}
+	</body>
+	</html>`
+
+var debugTemplate = template.Must(template.New("RPC debug").Parse(debugText))
+
+type debugHTTP struct {
+	*Server
+}
+
+type debugInfo struct {
+	Connections int            `json:"connections"`
+	InFlight    int            `json:"in_flight"`
+	Services    []debugService `json:"services"`
+}
+
+type debugService struct {
+	Name    string        `json:"name"`
+	Builtin bool          `json:"builtin"` // 框架内置的服务，不是通过 Register 注册的
+	Methods []debugMethod `json:"methods"`
+}
+
+type debugMethod struct {
+	Name       string        `json:"name"`
+	ArgType    string        `json:"arg_type"`
+	ReplyType  string        `json:"reply_type"`
+	Stream     bool          `json:"stream"`
+	Calls      uint64        `json:"calls"`
+	Errors     uint64        `json:"errors"`
+	LatencyP50 time.Duration `json:"latency_p50_ns"`
+	LatencyP90 time.Duration `json:"latency_p90_ns"`
+	LatencyP99 time.Duration `json:"latency_p99_ns"`
+}
+
+func (server debugHTTP) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	info := server.debugInfo()
+	if req.URL.Query().Get("format") == "json" || strings.Contains(req.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(info); err != nil {
+			server.logger().Error("rpc server: error encoding debug info", "err", err)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := debugTemplate.Execute(w, info); err != nil {
+		_, _ = w.Write([]byte("rpc: error executing template: " + err.Error()))
+	}
+}
+
+func (server debugHTTP) debugInfo() *debugInfo {
+	info := &debugInfo{}
+	info.Connections, info.InFlight = server.connStats()
+	server.ServiceMap.Range(func(name, svci interface{}) bool {
+		svc := svci.(*service)
+		ds := debugService{Name: svc.name, Builtin: isBuiltinService(svc.name)}
+		for name, m := range svc.method {
+			p := m.latency.percentiles(0.5, 0.9, 0.99)
+			ds.Methods = append(ds.Methods, debugMethod{
+				Name:       name,
+				ArgType:    m.ArgType.String(),
+				ReplyType:  m.ReplyType.String(),
+				Stream:     m.isStream,
+				Calls:      m.NumCalls(),
+				Errors:     m.NumErrors(),
+				LatencyP50: p[0],
+				LatencyP90: p[1],
+				LatencyP99: p[2],
+			})
+		}
+		sort.Slice(ds.Methods, func(i, j int) bool { return ds.Methods[i].Name < ds.Methods[j].Name })
+		info.Services = append(info.Services, ds)
+		return true
+	})
+	sort.Slice(info.Services, func(i, j int) bool {
+		a, b := info.Services[i], info.Services[j]
+		if a.Builtin != b.Builtin {
+			return b.Builtin
+		}
+		return a.Name < b.Name
+	})
+	return info
+}
+
+// 当前打开的连接数（包括握手阶段的连接）和正在处理的请求数
+func (svr *Server) connStats() (conns, inflight int) {
+	svr.mu.Lock()
+	defer svr.mu.Unlock()
+	for _, sc := range svr.conns {
+		conns++
+		if sc == nil {
+			continue
+		}
+		sc.mu.Lock()
+		inflight += len(sc.inflight)
+		sc.mu.Unlock()
+	}
+	return
+}
@@ -0,0 +1,77 @@
+package myrpc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDebugPage(t *testing.T) {
+	svr := &Server{}
+	addr := startTestServer(t, svr, Arith{}, Panicky{})
+	client := dialTestClient(t, addr, nil)
+	for i := 0; i < 3; i++ {
+		if err := client.Call(context.Background(), "Arith.Sum", ArithArgs{A: i}, new(int)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = client.Call(context.Background(), "Panicky.Fail", "no", new(int))
+	if err := client.Call(context.Background(), "_Reflection.ListServices", struct{}{}, new(ListServicesReply)); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	debugHTTP{svr}.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/myrpc?format=json", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	var info debugInfo
+	if err := json.NewDecoder(rec.Body).Decode(&info); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, s := range info.Services {
+		names = append(names, s.Name)
+		if s.Builtin != (s.Name == ReflectionServiceName) {
+			t.Errorf("service %s: builtin = %v", s.Name, s.Builtin)
+		}
+	}
+	// 内置服务排在最后
+	if got := strings.Join(names, ","); got != "Arith,Panicky,_Reflection" {
+		t.Fatalf("services = %s", got)
+	}
+	if m := info.Services[0].Methods[0]; m.Name != "Sum" || m.Calls != 3 || m.Errors != 0 || m.ArgType != "myrpc.ArithArgs" || m.ReplyType != "*int" {
+		t.Errorf("Arith.Sum = %+v", m)
+	}
+	for _, m := range info.Services[1].Methods {
+		if m.Name == "Fail" && (m.Calls != 1 || m.Errors != 1) {
+			t.Errorf("Panicky.Fail = %+v", m)
+		}
+	}
+
+	// 请求头 Accept: application/json 与 format=json 相同
+	req := httptest.NewRequest("GET", "/debug/myrpc", nil)
+	req.Header.Set("Accept", "application/json")
+	rec = httptest.NewRecorder()
+	debugHTTP{svr}.ServeHTTP(rec, req)
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("Accept: application/json: Content-Type = %q", ct)
+	}
+
+	rec = httptest.NewRecorder()
+	debugHTTP{svr}.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/myrpc", nil))
+	html := rec.Body.String()
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	for _, want := range []string{"Service Arith\n", "Service _Reflection (built-in)", "Sum(myrpc.ArithArgs, *int) error", "<td align=center>3</td>"} {
+		if !strings.Contains(html, want) {
+			t.Errorf("HTML does not contain %q:\n%s", want, html)
+		}
+	}
+	if strings.Index(html, "Service Panicky") > strings.Index(html, "Service _Reflection") {
+		t.Error("built-in service listed before user services")
+	}
+}
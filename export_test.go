@@ -0,0 +1,4 @@
+package myrpc
+
+// 供 myrpc_test 包中的测试使用
+var StartTestServer = startTestServer
@@ -3,10 +3,16 @@ package gateway
 import (
 	myrpc "MyRPC"
 	"MyRPC/codec"
+	"MyRPC/metadata"
+	"MyRPC/status"
+	"MyRPC/trace"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"net/http"
 	"reflect"
 	"strings"
@@ -18,19 +24,35 @@ type Gateway struct {
 	clientProxy *myrpc.Client
 	rpcServer   *myrpc.Server
 	httpServer  *http.Server
+
+	Logger *slog.Logger // 默认沿用 rpcServer.Logger，都为 nil 时使用 slog.Default()
+
+	// 非 nil 时 StartHttpProxy 使用 HTTPS，证书放在 TLSConfig.Certificates 中
+	TLSConfig *tls.Config
+}
+
+// BatchRequestItem 批量请求中的一项
+type BatchRequestItem struct {
+	Method string          `json:"method"` // 格式同 /rpc/ 路径：ServiceName.MethodName
+	Params json.RawMessage `json:"params,omitempty"`
 }
 
+// 一次批量请求最多包含的调用数
+const maxBatchSize = 1000
+
 // GatewayResponse HTTP 响应结构
 type GatewayResponse struct {
 	Success bool        `json:"success"`
 	Data    interface{} `json:"data,omitempty"`
 	Error   string      `json:"error,omitempty"`
+	Code    string      `json:"code,omitempty"` // 失败时的错误码，见 status 包
 }
 
 // NewGateway 创建新的网关实例
 // httpPort: HTTP 服务监听端口
-func NewGateway(rpcServer *myrpc.Server, httpPort string) *Gateway {
-	clientProxy, err := myrpc.Dial("tcp", rpcServer.Address)
+// opts: 连接 rpcServer 使用的 Option，rpcServer 使用 TLS 时需要在其中提供 TLSConfig
+func NewGateway(rpcServer *myrpc.Server, httpPort string, opts ...*myrpc.Option) *Gateway {
+	clientProxy, err := myrpc.Dial("tcp", rpcServer.Address, opts...)
 	if err != nil {
 		log.Fatalf("NewGateway: myrpc.Dail error: %v\n", err)
 	}
@@ -38,11 +60,13 @@ func NewGateway(rpcServer *myrpc.Server, httpPort string) *Gateway {
 	gateway := &Gateway{
 		clientProxy: clientProxy,
 		rpcServer:   rpcServer,
+		Logger:      rpcServer.Logger,
 	}
 
 	// 创建 HTTP 服务器
 	mux := http.NewServeMux()
 	mux.HandleFunc("/rpc/", gateway.handleRPCRequest)
+	mux.HandleFunc("/batch", gateway.handleBatchRequest)
 
 	gateway.httpServer = &http.Server{
 		Addr:    ":" + httpPort,
@@ -53,27 +77,39 @@ func NewGateway(rpcServer *myrpc.Server, httpPort string) *Gateway {
 }
 
 func (g *Gateway) StartHttpProxy() error {
-	log.Printf("Gateway starting on %s", g.httpServer.Addr)
+	g.logger().Info("gateway: starting", "addr", g.httpServer.Addr, "tls", g.TLSConfig != nil)
+	if g.TLSConfig != nil {
+		g.httpServer.TLSConfig = g.TLSConfig
+		return g.httpServer.ListenAndServeTLS("", "")
+	}
 	return g.httpServer.ListenAndServe()
 }
 
 func (g *Gateway) Stop() error {
-	log.Println("Gateway stopping...")
+	g.logger().Info("gateway: stopping")
 	if g.clientProxy != nil {
 		g.clientProxy.Close()
 	}
 	return g.httpServer.Shutdown(context.Background())
 }
 
+// ServeHTTP 处理 /rpc/ 和 /batch 请求，可以把 Gateway 挂到已有的 HTTP 服务上，不使用 StartHttpProxy
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.httpServer.Handler.ServeHTTP(w, r)
+}
+
+func (g *Gateway) logger() *slog.Logger {
+	if g.Logger != nil {
+		return g.Logger
+	}
+	return slog.Default()
+}
+
 // handleRPCRequest 处理 RPC 请求
 // URL 格式: /rpc/{ServiceName}.{MethodName}
 // 例如: /rpc/AuthService.Login
 func (g *Gateway) handleRPCRequest(w http.ResponseWriter, r *http.Request) {
-	// 设置 CORS 头
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-	w.Header().Set("Content-Type", "application/json")
+	setCommonHeaders(w)
 
 	// 处理 OPTIONS 请求
 	if r.Method == "OPTIONS" {
@@ -98,13 +134,147 @@ func (g *Gateway) handleRPCRequest(w http.ResponseWriter, r *http.Request) {
 		g.sendErrorResponse(w, "Service method not specified", http.StatusBadRequest)
 		return
 	}
-	log.Printf("service/method is %s\n", serviceMethod)
+	g.logger().Debug("gateway: rpc request", "service_method", serviceMethod, "remote_addr", r.RemoteAddr)
 	// 验证 serviceMethod 格式 (ServiceName.MethodName)
 	if !strings.Contains(serviceMethod, ".") {
 		g.sendErrorResponse(w, "Invalid service method format, expected 'ServiceName.MethodName'", http.StatusBadRequest)
 		return
 	}
 
+	ctx, span := startSpan(w, r, "gateway "+serviceMethod)
+	ctx = withAuthorization(ctx, r)
+	var err error
+	defer func() { span.Finish(err) }()
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		g.sendErrorResponse(w, fmt.Sprintf("Failed to read request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	callArg, replyv, err := g.newCallArgs(serviceMethod, body)
+	if err != nil {
+		g.sendStatusError(w, err)
+		return
+	}
+
+	// 调用 RPC 服务（使用本地 clientProxy）
+	// 请求经由 rpcServer 的 handleRequest 处理，同样会经过 rpcServer.Use 注册的拦截器链
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	err = g.clientProxy.Call(ctx, serviceMethod, callArg, replyv.Interface())
+	if err != nil {
+		g.logger().Warn("gateway: rpc call failed", "service_method", serviceMethod, "remote_addr", r.RemoteAddr, "err", err)
+		g.sendStatusError(w, err)
+		return
+	}
+
+	// 发送成功响应
+	g.sendSuccessResponse(w, replyv.Elem().Interface())
+}
+
+// handleBatchRequest 处理批量请求
+// 请求体为 BatchRequestItem 数组，响应体为按相同顺序排列的 GatewayResponse 数组
+// 单个调用失败不影响其他调用，整体的 HTTP 状态码只反映请求本身是否合法
+// 例如: POST /batch  [{"method": "Foo.Sum", "params": {"Num1": 1, "Num2": 2}}]
+func (g *Gateway) handleBatchRequest(w http.ResponseWriter, r *http.Request) {
+	setCommonHeaders(w)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "POST" {
+		g.sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var items []BatchRequestItem
+	if err := json.NewDecoder(r.Body).Decode(&items); err != nil {
+		g.sendErrorResponse(w, fmt.Sprintf("Failed to parse request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if len(items) > maxBatchSize {
+		g.sendErrorResponse(w, fmt.Sprintf("Too many calls in one batch, at most %d", maxBatchSize), http.StatusBadRequest)
+		return
+	}
+
+	ctx, span := startSpan(w, r, "gateway batch")
+	defer span.Finish(nil)
+	ctx = withAuthorization(ctx, r)
+
+	responses := make([]GatewayResponse, len(items))
+	calls := make([]*myrpc.Call, 0, len(items))
+	index := make([]int, 0, len(items)) // calls[i] 对应 items[index[i]]
+	replies := make([]reflect.Value, 0, len(items))
+	for i, item := range items {
+		callArg, replyv, err := g.newCallArgs(item.Method, item.Params)
+		if err != nil {
+			responses[i] = statusErrorResponse(err)
+			continue
+		}
+		calls = append(calls, &myrpc.Call{
+			ServiceMethod: item.Method,
+			Args:          callArg,
+			Reply:         replyv.Interface(),
+		})
+		index = append(index, i)
+		replies = append(replies, replyv)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	if err := g.clientProxy.Batch(ctx, calls); err != nil {
+		g.logger().Warn("gateway: rpc batch failed", "calls", len(calls), "remote_addr", r.RemoteAddr, "err", err)
+	}
+	for i, call := range calls {
+		if call.Error != nil {
+			responses[index[i]] = statusErrorResponse(call.Error)
+			continue
+		}
+		responses[index[i]] = GatewayResponse{
+			Success: true,
+			Data:    replies[i].Elem().Interface(),
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(responses)
+}
+
+// startSpan 以 HTTP 请求头中的 traceparent 为父节点创建 server span，之后的 RPC 调用都在同一条 trace 上
+// 响应头中返回这个 span 的 traceparent，方便调用方按 trace id 查找
+func startSpan(w http.ResponseWriter, r *http.Request, name string) (context.Context, *trace.Span) {
+	ctx := r.Context()
+	if sc, ok := trace.ParseTraceparent(r.Header.Get(trace.TraceparentKey)); ok {
+		sc.TraceState = r.Header.Get(trace.TracestateKey)
+		ctx = trace.ContextWithRemoteSpanContext(ctx, sc)
+	}
+	ctx, span := trace.Start(ctx, name, trace.KindServer)
+	span.SetAttribute("http.path", r.URL.Path)
+	w.Header().Set(trace.TraceparentKey, span.SpanContext().Traceparent())
+	return ctx, span
+}
+
+// withAuthorization 把 HTTP 的 Authorization 头原样作为 authorization 元数据转发给 rpcServer，由 rpcServer 的 Authenticator 校验
+// 格式见 myrpc 的 auth.go；HMAC 签名覆盖请求的 Seq 和编码后的 body，只能由 rpc 客户端自己生成，因此这里只适用于 Bearer token
+// clientProxy 的 Option.Credentials 非 nil 时以它提供的凭证为准
+func withAuthorization(ctx context.Context, r *http.Request) context.Context {
+	if v := r.Header.Get("Authorization"); v != "" {
+		return metadata.AppendToOutgoingContext(ctx, myrpc.AuthorizationKey, v)
+	}
+	return ctx
+}
+
+// setCommonHeaders 设置 CORS 头和响应类型
+func setCommonHeaders(w http.ResponseWriter) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, traceparent, tracestate")
+	w.Header().Set("Access-Control-Expose-Headers", "traceparent")
+	w.Header().Set("Content-Type", "application/json")
+}
+
+// newCallArgs 按服务方法的参数类型解码 json 参数
+// 返回用于调用的参数（与方法签名一致，值或指针）和接收结果的 reply（指针）
+func (g *Gateway) newCallArgs(serviceMethod string, params []byte) (interface{}, reflect.Value, error) {
 	rpcHeader := &codec.Header{
 		ServiceMethod: serviceMethod,
 		Seq:           0, // http 请求不需要 seqid
@@ -116,6 +286,12 @@ func (g *Gateway) handleRPCRequest(w http.ResponseWriter, r *http.Request) {
 	}
 	var err error
 	req.Svc, req.Mtype, err = g.rpcServer.FindService(rpcHeader.ServiceMethod)
+	if err != nil {
+		return nil, reflect.Value{}, err
+	}
+	if req.Mtype.IsStream() {
+		return nil, reflect.Value{}, status.Errorf(status.Unimplemented, "gateway: streaming method %s is not supported", serviceMethod)
+	}
 	// 基于 server 端 map 中存储的 method 信息拿到参数和返回值信息
 	argv := req.Mtype.NewArgv()
 	replyv := req.Mtype.NewReplyv()
@@ -125,37 +301,19 @@ func (g *Gateway) handleRPCRequest(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 读取请求体到正确的类型结构中
-	if r.ContentLength > 0 {
-		decoder := json.NewDecoder(r.Body)
-		if err := decoder.Decode(argvi); err != nil {
-			g.sendErrorResponse(w, fmt.Sprintf("Failed to parse request body: %v", err), http.StatusBadRequest)
-			return
+	if len(params) > 0 {
+		if err := json.Unmarshal(params, argvi); err != nil {
+			return nil, reflect.Value{}, status.Errorf(status.InvalidArgument, "Failed to parse request body: %v", err)
 		}
 	}
 
-	// 调用 RPC 服务（使用本地 clientProxy）
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-	// 使用解析好的参数和返回值类型进行调用
 	// 注意：argvi 已经包含了解码后的数据，而且可能是指针类型
-	var callArg interface{}
 	if argv.Type().Kind() != reflect.Ptr {
 		// 如果原始类型不是指针，传递值
-		callArg = argv.Interface()
-	} else {
-		// 如果原始类型是指针，传递指针
-		callArg = argvi
+		return argv.Interface(), replyv, nil
 	}
-
-	err = g.clientProxy.Call(ctx, serviceMethod, callArg, replyv.Interface())
-	if err != nil {
-		log.Printf("RPC call failed: %v", err)
-		g.sendErrorResponse(w, fmt.Sprintf("RPC call failed: %v", err), http.StatusInternalServerError)
-		return
-	}
-
-	// 发送成功响应
-	g.sendSuccessResponse(w, replyv.Elem().Interface())
+	// 如果原始类型是指针，传递指针
+	return argvi, replyv, nil
 }
 
 // sendSuccessResponse 发送成功响应
@@ -179,3 +337,47 @@ func (g *Gateway) sendErrorResponse(w http.ResponseWriter, message string, statu
 	w.WriteHeader(statusCode)
 	json.NewEncoder(w).Encode(response)
 }
+
+// sendStatusError 按错误码映射 HTTP 状态码，发送错误响应
+func (g *Gateway) sendStatusError(w http.ResponseWriter, err error) {
+	w.WriteHeader(httpStatusFromCode(status.Convert(err).Code))
+	json.NewEncoder(w).Encode(statusErrorResponse(err))
+}
+
+func statusErrorResponse(err error) GatewayResponse {
+	st := status.Convert(err)
+	return GatewayResponse{
+		Success: false,
+		Error:   st.Message,
+		Code:    st.Code.String(),
+	}
+}
+
+func httpStatusFromCode(code status.Code) int {
+	switch code {
+	case status.OK:
+		return http.StatusOK
+	case status.Canceled:
+		return 499 // 客户端关闭了请求，沿用 nginx 的约定
+	case status.InvalidArgument, status.OutOfRange, status.FailedPrecondition:
+		return http.StatusBadRequest
+	case status.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case status.NotFound:
+		return http.StatusNotFound
+	case status.AlreadyExists, status.Aborted:
+		return http.StatusConflict
+	case status.PermissionDenied:
+		return http.StatusForbidden
+	case status.Unauthenticated:
+		return http.StatusUnauthorized
+	case status.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case status.Unimplemented:
+		return http.StatusNotImplemented
+	case status.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
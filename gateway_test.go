@@ -0,0 +1,81 @@
+package myrpc_test
+
+import (
+	myrpc "MyRPC"
+	"MyRPC/gateway"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestGateway(t *testing.T) *httptest.Server {
+	t.Helper()
+	svr := &myrpc.Server{}
+	myrpc.StartTestServer(t, svr, myrpc.Arith{})
+	gw := gateway.NewGateway(svr, "0")
+	hs := httptest.NewServer(gw)
+	t.Cleanup(func() {
+		hs.Close()
+		_ = gw.Stop()
+	})
+	return hs
+}
+
+func postGateway(t *testing.T, url, body string, v interface{}) int {
+	t.Helper()
+	rsp, err := http.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rsp.Body.Close()
+	if err := json.NewDecoder(rsp.Body).Decode(v); err != nil {
+		t.Fatal(err)
+	}
+	return rsp.StatusCode
+}
+
+func TestGatewayCall(t *testing.T) {
+	hs := newTestGateway(t)
+
+	var rsp gateway.GatewayResponse
+	if code := postGateway(t, hs.URL+"/rpc/Arith.Sum", `{"A": 1, "B": 2}`, &rsp); code != http.StatusOK || !rsp.Success || rsp.Data != 3.0 {
+		t.Fatalf("Arith.Sum: HTTP %d, %+v", code, rsp)
+	}
+	rsp = gateway.GatewayResponse{}
+	if code := postGateway(t, hs.URL+"/rpc/Arith.Missing", `{}`, &rsp); code != http.StatusNotFound || rsp.Success || rsp.Code != "NotFound" {
+		t.Fatalf("Arith.Missing: HTTP %d, %+v", code, rsp)
+	}
+}
+
+func TestGatewayBatch(t *testing.T) {
+	hs := newTestGateway(t)
+
+	var rsps []gateway.GatewayResponse
+	code := postGateway(t, hs.URL+"/batch", `[
+		{"method": "Arith.Sum", "params": {"A": 1, "B": 2}},
+		{"method": "Arith.Missing"},
+		{"method": "Arith.Sum", "params": {"A": "x"}},
+		{"method": "Arith.Sum", "params": {"A": 10, "B": 20}}
+	]`, &rsps)
+	if code != http.StatusOK || len(rsps) != 4 {
+		t.Fatalf("HTTP %d, %d responses", code, len(rsps))
+	}
+	for i, want := range []gateway.GatewayResponse{
+		{Success: true, Data: 3.0},
+		{Code: "NotFound"},
+		{Code: "InvalidArgument"},
+		{Success: true, Data: 30.0},
+	} {
+		got := rsps[i]
+		if got.Success != want.Success || got.Data != want.Data || got.Code != want.Code {
+			t.Errorf("response %d = %+v, want %+v", i, got, want)
+		}
+	}
+
+	var rsp gateway.GatewayResponse
+	if code := postGateway(t, hs.URL+"/batch", `{"method": "Arith.Sum"}`, &rsp); code != http.StatusBadRequest || rsp.Success {
+		t.Fatalf("malformed batch: HTTP %d, %+v", code, rsp)
+	}
+}
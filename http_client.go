@@ -59,16 +59,34 @@ func DialHTTP(network, address string, opts ...*Option) (*Client, error) {
 }
 
 // 参数 rpcAddr 形如 http@10.0.0.1:8080，tcp@10.0.0.1:8089, unix@tmp/myrpc.sock
+// tls@ 和 https@ 分别是 tcp@ 和 http@ 的 TLS 版本，见 tls.go
 func XDial(rpcAddr string, opts ...*Option) (*Client, error) {
+	f, network, addr, opts, err := parseRPCAddr(rpcAddr, opts)
+	if err != nil {
+		return nil, err
+	}
+	return dialWithTimeout(f, network, addr, opts...)
+}
+
+// 将 rpcAddr 解析为 dialWithTimeout 的参数，XDialReconnecting 也使用它
+func parseRPCAddr(rpcAddr string, opts []*Option) (f newClientFunc, network, addr string, _ []*Option, err error) {
 	parts := strings.Split(rpcAddr, "@")
 	if len(parts) != 2 {
-		return nil, fmt.Errorf("client error: wrong rpcAddr")
+		return nil, "", "", nil, fmt.Errorf("client error: wrong rpcAddr")
 	}
 	protocol, addr := parts[0], parts[1]
 	switch protocol {
 	case "http":
-		return DialHTTP("tcp", addr, opts...)
+		return NewHTTPClient, "tcp", addr, opts, nil
+	case "tls", "https":
+		if opts, err = withTLS(opts); err != nil {
+			return nil, "", "", nil, err
+		}
+		if protocol == "https" {
+			return NewHTTPClient, "tcp", addr, opts, nil
+		}
+		return NewClient, "tcp", addr, opts, nil
 	default:
-		return Dial(protocol, addr, opts...)
+		return NewClient, protocol, addr, opts, nil
 	}
 }
@@ -1,8 +1,8 @@
 package myrpc
 
 import (
+	"MyRPC/metrics"
 	"io"
-	"log"
 	"net/http"
 )
 
@@ -45,15 +45,19 @@ func (server *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	}
 	conn, _, err := w.(http.Hijacker).Hijack() // 此后使用 TCP 连接，不再受限于 HTTP 的请求 - 响应模式
 	if err != nil {
-		log.Print("rpc hijacking", req.RemoteAddr, ": ", err.Error())
+		server.logger().Error("rpc server: hijacking error", "remote_addr", req.RemoteAddr, "err", err)
 		return
 	}
 
-	_, _ = io.WriteString(conn, "HTTP/1.0" + connected + "\n\n")
+	_, _ = io.WriteString(conn, "HTTP/1.0 "+connected + "\n\n")
 	server.ServeConn(conn)
 }
 
 
+// 同时在 defaultDebugPath 上注册调试页面，见 debug.go；在 /metrics 上输出指标，见 metrics.go
 func (server *Server) HandleHTTP() {
 	http.Handle(defaultRPCPath, server) // 我们的 server 实现了 Handler 接口
+	http.Handle(defaultDebugPath, debugHTTP{server})
+	metrics.HandleHTTP()
+	server.logger().Info("rpc server: debug path registered", "path", defaultDebugPath)
 }
\ No newline at end of file
@@ -0,0 +1,129 @@
+/*
+
+拦截器
+
+服务端：在 readRequest 解码出参数之后、调用服务方法之前插入的处理逻辑，如日志、鉴权、监控、panic 处理等
+客户端：包裹 Client.Call / Go 以及 XClient.Call / Broadcast，如重试、日志、监控、注入鉴权 token、故障注入等
+
+多个拦截器按注册顺序组成调用链，先注册的在外层：
+
+	interceptor1 -> interceptor2 -> ... -> service.call / Client.invoke
+
+每个拦截器可以修改 ctx / 参数，也可以不调用 next 直接返回
+
+*/
+
+package myrpc
+
+import (
+	"MyRPC/metadata"
+	"MyRPC/status"
+	"context"
+	"reflect"
+	"runtime/debug"
+	"time"
+)
+
+// 拦截器链上的下一个处理函数，链的末端是真正的服务方法
+type Handler func(ctx context.Context, argv interface{}) (reply interface{}, err error)
+
+// 拦截器可以拿到的调用信息
+type ServerInfo struct {
+	ServiceMethod string
+	Metadata      metadata.MD // 请求头中的元数据
+	RemoteAddr    string      // 客户端地址，连接不是 net.Conn 时为空
+	Peer          *Peer       // 连接信息，mTLS 时包含验证过的客户端证书，见 tls.go
+	Auth          *AuthInfo   // 认证结果，没有设置 Server.Authenticator 时为 nil，见 auth.go
+}
+
+type ServerInterceptor func(ctx context.Context, info *ServerInfo, argv interface{}, next Handler) (reply interface{}, err error)
+
+// 注册拦截器，按注册顺序执行；需要在处理请求之前完成注册
+func (svr *Server) Use(interceptors ...ServerInterceptor) {
+	svr.mu.Lock()
+	defer svr.mu.Unlock()
+	svr.interceptors = append(svr.interceptors, interceptors...)
+}
+
+// 经过拦截器链调用 req 对应的服务方法
+// 拦截器或服务方法中的 panic 会被恢复并作为 error 返回给调用方，除非设置了 DisableRecovery
+func (svr *Server) invoke(ctx context.Context, req *Request, info *ServerInfo) (reply interface{}, err error) {
+	observe := observeServerRequest(info.ServiceMethod)
+	start := time.Now()
+	defer func() { // 在 recover 之后执行，panic 计为 Internal
+		// 超过 HandleTimeout 之后返回的结果不再有效，客户端收到的是 errHandleTimeout，指标和日志以此为准
+		if context.Cause(ctx) == errHandleTimeout {
+			reply, err = nil, errHandleTimeout
+		}
+		observe(err)
+		if svr.AccessLog {
+			svr.logAccess(req, info, time.Since(start), err)
+		}
+	}()
+	if !svr.DisableRecovery {
+		defer func() {
+			if r := recover(); r != nil {
+				svr.logger().Error("rpc server: panic in service method", "service_method", info.ServiceMethod,
+					"seq", req.H.Seq, "remote_addr", info.RemoteAddr, "panic", r, "stack", string(debug.Stack()))
+				reply, err = nil, status.Errorf(status.Internal, "rpc server: panic in %s: %v", info.ServiceMethod, r)
+			}
+		}()
+	}
+
+	ctx, err = svr.authenticate(ctx, info, req)
+	if err != nil {
+		return nil, err
+	}
+	if err = svr.authorize(ctx, info); err != nil {
+		return nil, err
+	}
+
+	svr.mu.Lock()
+	interceptors := svr.interceptors
+	svr.mu.Unlock()
+
+	var h Handler = func(ctx context.Context, argv interface{}) (interface{}, error) {
+		av := reflect.ValueOf(argv)
+		if argv == nil {
+			av = reflect.Zero(req.Mtype.ArgType)
+		}
+		err := req.Svc.call(ctx, req.Mtype, av, req.replyv)
+		return req.replyv.Interface(), err
+	}
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], h
+		h = func(ctx context.Context, argv interface{}) (interface{}, error) {
+			return interceptor(ctx, info, argv, next)
+		}
+	}
+	return h(ctx, req.argv.Interface())
+}
+
+// 客户端拦截器链上的下一个处理函数，链的末端真正发起调用
+type Invoker func(ctx context.Context, serviceMethod string, args, reply interface{}, opts ...CallOption) error
+
+type ClientInterceptor func(ctx context.Context, serviceMethod string, args, reply interface{}, invoker Invoker, opts ...CallOption) error
+
+// 注册拦截器，按注册顺序执行
+func (client *Client) Use(interceptors ...ClientInterceptor) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.interceptors = append(client.interceptors, interceptors...)
+}
+
+func (client *Client) getInterceptors() []ClientInterceptor {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return client.interceptors
+}
+
+// 将拦截器和末端的 invoker 组装成一个 Invoker，XClient 也使用它组装自己的拦截器链
+func ChainClientInterceptors(interceptors []ClientInterceptor, invoker Invoker) Invoker {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], invoker
+		invoker = func(ctx context.Context, serviceMethod string, args, reply interface{}, opts ...CallOption) error {
+			return interceptor(ctx, serviceMethod, args, reply, next, opts...)
+		}
+	}
+	return invoker
+}
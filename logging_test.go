@@ -0,0 +1,126 @@
+package myrpc
+
+import (
+	"MyRPC/registry"
+	"context"
+	"log/slog"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 记录所有级别的日志，With 添加的属性合并到每条记录中
+type captureHandler struct {
+	store *recordStore
+	attrs []slog.Attr
+}
+
+type recordStore struct {
+	mu      sync.Mutex
+	records []slog.Record
+}
+
+func newCaptureLogger() (*slog.Logger, *recordStore) {
+	store := &recordStore{}
+	return slog.New(&captureHandler{store: store}), store
+}
+
+func (h *captureHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h *captureHandler) Handle(_ context.Context, r slog.Record) error {
+	r = r.Clone()
+	r.AddAttrs(h.attrs...)
+	h.store.mu.Lock()
+	defer h.store.mu.Unlock()
+	h.store.records = append(h.store.records, r)
+	return nil
+}
+
+func (h *captureHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &captureHandler{store: h.store, attrs: append(append([]slog.Attr(nil), h.attrs...), attrs...)}
+}
+
+func (h *captureHandler) WithGroup(string) slog.Handler { return h }
+
+// 第一条消息为 msg 的记录的属性；还没有这条记录时返回 false
+func (s *recordStore) find(msg string) (map[string]string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range s.records {
+		if r.Message != msg {
+			continue
+		}
+		attrs := make(map[string]string)
+		r.Attrs(func(a slog.Attr) bool {
+			attrs[a.Key] = a.Value.String()
+			return true
+		})
+		return attrs, true
+	}
+	return nil, false
+}
+
+func (s *recordStore) wait(t *testing.T, msg string) map[string]string {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if attrs, ok := s.find(msg); ok {
+			return attrs
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no %q record was logged", msg)
+		}
+	}
+}
+
+func TestServerLogger(t *testing.T) {
+	regLogger, regRecords := newCaptureLogger()
+	reg := registry.New(0)
+	registry.WithLogger(regLogger)(reg)
+	regServer := httptest.NewServer(reg)
+	defer regServer.Close()
+
+	logger, records := newCaptureLogger()
+	ch := make(chan *Server)
+	go NewServer(regServer.URL, ch, WithLogger(logger), WithAccessLog(true))
+	svr := <-ch
+	t.Cleanup(func() { _ = svr.Close() })
+	if err := svr.Register(&Audit{events: make(chan string, 1)}); err != nil {
+		t.Fatal(err)
+	}
+	records.wait(t, "rpc server: starting")
+
+	client := dialTestClient(t, svr.Address, nil)
+	var reply int
+	if err := client.Call(context.Background(), "Audit.Reject", "x", &reply); err == nil {
+		t.Fatal("Reject succeeded")
+	}
+	attrs := records.wait(t, "rpc access")
+	if attrs["service_method"] != "Audit.Reject" || attrs["code"] != "FailedPrecondition" || attrs["err"] == "" {
+		t.Fatalf("access log attrs = %v", attrs)
+	}
+
+	// registry 的日志写到它自己的 logger，服务端的心跳和注销都有记录
+	if attrs := regRecords.wait(t, "registry: server registered"); attrs["server"] != "tcp@"+svr.Address {
+		t.Fatalf("registered attrs = %v, want server tcp@%s", attrs, svr.Address)
+	}
+	_ = svr.Close()
+	regRecords.wait(t, "registry: server deregistered")
+	if _, ok := records.find("registry: server registered"); ok {
+		t.Fatal("registry records went to the server logger")
+	}
+}
+
+// 没有打开 AccessLog 时不记录每个请求
+func TestAccessLogDisabled(t *testing.T) {
+	logger, records := newCaptureLogger()
+	addr := startTestServer(t, &Server{Logger: logger}, &Audit{events: make(chan string, 1)})
+	client := dialTestClient(t, addr, nil)
+	var reply int
+	if err := client.Call(context.Background(), "Audit.Record", "x", &reply); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := records.find("rpc access"); ok {
+		t.Fatal("access log written with AccessLog off")
+	}
+}
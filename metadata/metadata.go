@@ -0,0 +1,129 @@
+/*
+请求 / 响应的元数据，随 codec.Header 一起在连接上传输
+
+客户端：通过 NewOutgoingContext / AppendToOutgoingContext 把元数据放进 ctx，Client.Call 会将其写入请求头
+服务端：通过 FromIncomingContext 读取请求头中的元数据，通过 SetResponse 设置写回给客户端的元数据
+*/
+
+package metadata
+
+import (
+	"context"
+	"sync"
+)
+
+// MD 即 codec.Header.Metadata，key 和 value 都是字符串，如 trace id、租户 id、鉴权 token 等
+type MD map[string]string
+
+func New(m map[string]string) MD {
+	md := make(MD, len(m))
+	for k, v := range m {
+		md[k] = v
+	}
+	return md
+}
+
+// 以 key, value, key, value ... 的形式构造 MD，参数个数为奇数时 panic
+func Pairs(kv ...string) MD {
+	if len(kv)%2 == 1 {
+		panic("metadata: Pairs got an odd number of input pairs")
+	}
+	md := make(MD, len(kv)/2)
+	for i := 0; i < len(kv); i += 2 {
+		md[kv[i]] = kv[i+1]
+	}
+	return md
+}
+
+func (md MD) Get(key string) string {
+	return md[key]
+}
+
+func (md MD) Set(key, value string) {
+	md[key] = value
+}
+
+func (md MD) Copy() MD {
+	return New(md)
+}
+
+// 合并多个 MD，后面的覆盖前面的同名 key
+func Join(mds ...MD) MD {
+	ret := MD{}
+	for _, md := range mds {
+		for k, v := range md {
+			ret[k] = v
+		}
+	}
+	return ret
+}
+
+type outgoingKey struct{}
+type incomingKey struct{}
+type responseKey struct{}
+
+// 客户端：返回携带 md 的 ctx，会覆盖 ctx 中已有的 outgoing 元数据
+func NewOutgoingContext(ctx context.Context, md MD) context.Context {
+	return context.WithValue(ctx, outgoingKey{}, md)
+}
+
+// 客户端：在 ctx 已有的 outgoing 元数据上追加键值对，不修改原有的 MD
+func AppendToOutgoingContext(ctx context.Context, kv ...string) context.Context {
+	md, _ := FromOutgoingContext(ctx)
+	return NewOutgoingContext(ctx, Join(md, Pairs(kv...)))
+}
+
+func FromOutgoingContext(ctx context.Context) (MD, bool) {
+	md, ok := ctx.Value(outgoingKey{}).(MD)
+	return md, ok
+}
+
+// 服务端：由框架调用，把请求头中的元数据放进传给服务方法的 ctx
+func NewIncomingContext(ctx context.Context, md MD) context.Context {
+	return context.WithValue(ctx, incomingKey{}, md)
+}
+
+// 服务端：服务方法通过 ctx 读取客户端发送的元数据
+func FromIncomingContext(ctx context.Context) (MD, bool) {
+	md, ok := ctx.Value(incomingKey{}).(MD)
+	return md, ok
+}
+
+// 响应元数据的容器，超时返回时处理协程可能仍在写入，需要加锁
+type responseMD struct {
+	mu sync.Mutex
+	md MD
+}
+
+// 服务端：由框架调用，为每个请求准备一个用于收集响应元数据的容器
+func NewResponseContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, responseKey{}, &responseMD{md: MD{}})
+}
+
+// 服务端：服务方法设置需要随响应返回给客户端的元数据
+func SetResponse(ctx context.Context, kv ...string) bool {
+	r, ok := ctx.Value(responseKey{}).(*responseMD)
+	if !ok {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for k, v := range Pairs(kv...) {
+		r.md[k] = v
+	}
+	return true
+}
+
+// 服务端：由框架调用，取出服务方法设置的响应元数据的副本
+func ResponseFromContext(ctx context.Context) MD {
+	r, ok := ctx.Value(responseKey{}).(*responseMD)
+	if !ok {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.md) == 0 {
+		return nil
+	}
+	return r.md.Copy()
+}
@@ -0,0 +1,47 @@
+package myrpc
+
+import (
+	"MyRPC/codec"
+	"MyRPC/metadata"
+	"context"
+	"testing"
+)
+
+type Meta struct{}
+
+// 返回请求元数据中 key 对应的值，并把 "echo-" + key 设置到响应元数据中
+func (Meta) Get(ctx context.Context, key string, reply *string) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	*reply = md.Get(key)
+	metadata.SetResponse(ctx, "echo-"+key, *reply)
+	return nil
+}
+
+func TestMetadata(t *testing.T) {
+	addr := startTestServer(t, &Server{}, Meta{})
+	for _, ct := range []codec.Type{codec.GobType, codec.JsonType, codec.GobFrameType, codec.JsonFrameType} {
+		client := dialTestClient(t, addr, &Option{CodecType: ct})
+
+		ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("tenant", "t1"))
+		ctx = metadata.AppendToOutgoingContext(ctx, "request-id", "r1")
+		for key, want := range map[string]string{"tenant": "t1", "request-id": "r1", "missing": ""} {
+			var reply string
+			var rsp metadata.MD
+			if err := client.Call(ctx, "Meta.Get", key, &reply, WithResponseMetadata(&rsp)); err != nil {
+				t.Fatal(err)
+			}
+			if reply != want {
+				t.Errorf("%s: server saw %s = %q, want %q", ct, key, reply, want)
+			}
+			if got := rsp.Get("echo-" + key); got != want {
+				t.Errorf("%s: response metadata echo-%s = %q, want %q", ct, key, got, want)
+			}
+		}
+
+		// 没有元数据的调用
+		var reply string
+		if err := client.Call(context.Background(), "Meta.Get", "tenant", &reply); err != nil || reply != "" {
+			t.Fatalf("%s: Get without metadata = %q, %v", ct, reply, err)
+		}
+	}
+}
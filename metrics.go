@@ -0,0 +1,120 @@
+/*
+
+服务端和客户端的指标，按 Prometheus 文本格式输出，见 metrics 包
+
+服务端：每个方法的请求数、按错误码的错误数、耗时直方图、正在处理的请求数，
+	   按 Codec 统计的收发字节数，连接数
+客户端：每个方法的请求数、按错误码的错误数、耗时直方图、等待响应的调用数，
+	   按 Codec 统计的收发字节数，连接数，建立连接失败的次数（XClient 通过 Client 统计）
+
+HandleHTTP 会在 /metrics 上注册输出，也可以自行使用 metrics.Handler()
+
+*/
+
+package myrpc
+
+import (
+	"MyRPC/metrics"
+	"MyRPC/status"
+	"io"
+	"time"
+)
+
+var (
+	serverRequests = metrics.NewCounterVec("myrpc_server_requests_total",
+		"Requests handled by the server.", "method")
+	serverErrors = metrics.NewCounterVec("myrpc_server_errors_total",
+		"Requests answered with an error, by status code.", "method", "code")
+	serverLatency = metrics.NewHistogramVec("myrpc_server_request_duration_seconds",
+		"Time spent in the service method, including interceptors.", nil, "method")
+	serverInFlight = metrics.NewGaugeVec("myrpc_server_in_flight_requests",
+		"Requests currently being handled.", "method")
+	serverBytesIn = metrics.NewCounterVec("myrpc_server_received_bytes_total",
+		"Bytes read from client connections after the handshake.", "codec")
+	serverBytesOut = metrics.NewCounterVec("myrpc_server_sent_bytes_total",
+		"Bytes written to client connections.", "codec")
+	serverConns = metrics.NewGaugeVec("myrpc_server_connections",
+		"Currently open client connections.")
+	serverConnsTotal = metrics.NewCounterVec("myrpc_server_connections_total",
+		"Client connections accepted since start.")
+
+	clientRequests = metrics.NewCounterVec("myrpc_client_requests_total",
+		"Calls made by clients.", "method")
+	clientErrors = metrics.NewCounterVec("myrpc_client_errors_total",
+		"Calls that finished with an error, by status code.", "method", "code")
+	clientLatency = metrics.NewHistogramVec("myrpc_client_request_duration_seconds",
+		"Time from sending a call to receiving its result.", nil, "method")
+	clientPending = metrics.NewGaugeVec("myrpc_client_pending_calls",
+		"Calls sent and waiting for a response.")
+	clientBytesIn = metrics.NewCounterVec("myrpc_client_received_bytes_total",
+		"Bytes read from server connections after the handshake.", "codec")
+	clientBytesOut = metrics.NewCounterVec("myrpc_client_sent_bytes_total",
+		"Bytes written to server connections after the handshake.", "codec")
+	clientConns = metrics.NewGaugeVec("myrpc_client_connections",
+		"Currently open server connections.")
+	clientDialFailures = metrics.NewCounterVec("myrpc_client_dial_failures_total",
+		"Failed attempts to connect to a server.")
+)
+
+// 服务端找不到方法等情况下不使用客户端传来的方法名，避免标签数量不受控制
+const unknownMethod = "unknown"
+
+func requestMethod(req *Request) string {
+	if req.Mtype == nil {
+		return unknownMethod
+	}
+	return req.H.ServiceMethod
+}
+
+// 开始处理一个请求，返回的函数在处理结束时调用
+func observeServerRequest(method string) func(err error) {
+	inFlight := serverInFlight.WithLabelValues(method)
+	inFlight.Inc()
+	start := time.Now()
+	return func(err error) {
+		inFlight.Dec()
+		serverRequests.WithLabelValues(method).Inc()
+		serverLatency.WithLabelValues(method).Observe(time.Since(start).Seconds())
+		if err != nil {
+			serverErrors.WithLabelValues(method, status.CodeOf(err).String()).Inc()
+		}
+	}
+}
+
+// 由 call.done 调用，每个发出的调用只统计一次
+func observeClientCall(call *Call) {
+	if call.start.IsZero() {
+		return
+	}
+	clientRequests.WithLabelValues(call.ServiceMethod).Inc()
+	clientLatency.WithLabelValues(call.ServiceMethod).Observe(time.Since(call.start).Seconds())
+	if call.Error != nil {
+		clientErrors.WithLabelValues(call.ServiceMethod, status.CodeOf(call.Error).String()).Inc()
+	}
+}
+
+// 统计收发字节数的连接
+type meteredConn struct {
+	io.ReadWriteCloser
+	in, out *metrics.Counter
+}
+
+func newMeteredConn(conn io.ReadWriteCloser, in, out *metrics.Counter) io.ReadWriteCloser {
+	return &meteredConn{ReadWriteCloser: conn, in: in, out: out}
+}
+
+func (c *meteredConn) Read(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Read(p)
+	if n > 0 {
+		c.in.Add(float64(n))
+	}
+	return n, err
+}
+
+func (c *meteredConn) Write(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Write(p)
+	if n > 0 {
+		c.out.Add(float64(n))
+	}
+	return n, err
+}
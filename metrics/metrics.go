@@ -0,0 +1,333 @@
+/*
+
+指标：只依赖标准库的 Counter / Gauge / Histogram，按 Prometheus 文本格式输出
+
+	var requests = metrics.NewCounterVec("myrpc_server_requests_total", "...", "method")
+	requests.WithLabelValues("Foo.Sum").Inc()
+
+	http.Handle("/metrics", metrics.Handler())
+
+所有指标注册在 Default 中；同名指标重复注册时返回已有的那个，
+因此同一进程中的多个 Server / Client 共享同一组指标
+
+*/
+
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const defaultMetricsPath = "/metrics"
+
+// 默认的直方图分桶，单位秒
+var DefBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type metricType string
+
+const (
+	counterType   metricType = "counter"
+	gaugeType     metricType = "gauge"
+	histogramType metricType = "histogram"
+)
+
+// 一组同名、标签名相同的指标
+type family struct {
+	name    string
+	help    string
+	typ     metricType
+	labels  []string
+	buckets []float64 // 只有 histogram 使用
+
+	mu       sync.Mutex
+	children map[string]*child // key 为拼接后的标签值
+}
+
+// 一组标签值对应的一条时间序列
+type child struct {
+	labelValues []string
+	val         atomicFloat // counter / gauge 的值，histogram 的 sum
+	count       uint64      // histogram 的样本数
+	bucketCount []uint64    // histogram 每个分桶的样本数（不累加）
+}
+
+type atomicFloat struct {
+	bits uint64
+}
+
+func (f *atomicFloat) add(v float64) {
+	for {
+		old := atomic.LoadUint64(&f.bits)
+		n := math.Float64bits(math.Float64frombits(old) + v)
+		if atomic.CompareAndSwapUint64(&f.bits, old, n) {
+			return
+		}
+	}
+}
+
+func (f *atomicFloat) set(v float64) {
+	atomic.StoreUint64(&f.bits, math.Float64bits(v))
+}
+
+func (f *atomicFloat) load() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&f.bits))
+}
+
+func (fam *family) with(values []string) *child {
+	if len(values) != len(fam.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", fam.name, len(fam.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	fam.mu.Lock()
+	defer fam.mu.Unlock()
+	c := fam.children[key]
+	if c == nil {
+		c = &child{labelValues: append([]string(nil), values...)}
+		if fam.typ == histogramType {
+			c.bucketCount = make([]uint64, len(fam.buckets))
+		}
+		fam.children[key] = c
+	}
+	return c
+}
+
+// 指标的集合
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+var Default = NewRegistry()
+
+func (r *Registry) register(name, help string, typ metricType, buckets []float64, labels []string) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if fam := r.families[name]; fam != nil {
+		if fam.typ != typ || strings.Join(fam.labels, ",") != strings.Join(labels, ",") {
+			panic(fmt.Sprintf("metrics: %s already registered as a different %s", name, fam.typ))
+		}
+		return fam
+	}
+	fam := &family{
+		name:     name,
+		help:     help,
+		typ:      typ,
+		labels:   labels,
+		buckets:  buckets,
+		children: make(map[string]*child),
+	}
+	r.families[name] = fam
+	return fam
+}
+
+type CounterVec struct{ fam *family }
+
+// 只增不减的计数
+type Counter struct{ c *child }
+
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{r.register(name, help, counterType, nil, labels)}
+}
+
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return Default.NewCounterVec(name, help, labels...)
+}
+
+func (v *CounterVec) WithLabelValues(values ...string) *Counter {
+	return &Counter{v.fam.with(values)}
+}
+
+func (c *Counter) Inc() { c.c.val.add(1) }
+
+// v 不能为负数
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		panic("metrics: counter cannot decrease")
+	}
+	c.c.val.add(v)
+}
+
+type GaugeVec struct{ fam *family }
+
+// 可增可减的瞬时值
+type Gauge struct{ c *child }
+
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{r.register(name, help, gaugeType, nil, labels)}
+}
+
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return Default.NewGaugeVec(name, help, labels...)
+}
+
+func (v *GaugeVec) WithLabelValues(values ...string) *Gauge {
+	return &Gauge{v.fam.with(values)}
+}
+
+func (g *Gauge) Inc()          { g.c.val.add(1) }
+func (g *Gauge) Dec()          { g.c.val.add(-1) }
+func (g *Gauge) Add(v float64) { g.c.val.add(v) }
+func (g *Gauge) Set(v float64) { g.c.val.set(v) }
+
+type HistogramVec struct{ fam *family }
+
+// 按分桶统计样本分布
+type Histogram struct {
+	c       *child
+	buckets []float64
+}
+
+// buckets 为各分桶的上界，需要递增；为 nil 时使用 DefBuckets
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	return &HistogramVec{r.register(name, help, histogramType, buckets, labels)}
+}
+
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return Default.NewHistogramVec(name, help, buckets, labels...)
+}
+
+func (v *HistogramVec) WithLabelValues(values ...string) *Histogram {
+	return &Histogram{c: v.fam.with(values), buckets: v.fam.buckets}
+}
+
+func (h *Histogram) Observe(v float64) {
+	// 落在第一个上界不小于 v 的分桶，超过所有上界时只计入 +Inf
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		atomic.AddUint64(&h.c.bucketCount[i], 1)
+	}
+	atomic.AddUint64(&h.c.count, 1)
+	h.c.val.add(v)
+}
+
+// 按 Prometheus 文本格式（version 0.0.4）输出所有指标
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	fams := make([]*family, 0, len(r.families))
+	for _, fam := range r.families {
+		fams = append(fams, fam)
+	}
+	r.mu.Unlock()
+	sort.Slice(fams, func(i, j int) bool { return fams[i].name < fams[j].name })
+
+	bw := bufio.NewWriter(w)
+	for _, fam := range fams {
+		fam.write(bw)
+	}
+	return bw.Flush()
+}
+
+func (fam *family) write(w *bufio.Writer) {
+	fam.mu.Lock()
+	children := make([]*child, 0, len(fam.children))
+	for _, c := range fam.children {
+		children = append(children, c)
+	}
+	fam.mu.Unlock()
+	if len(children) == 0 {
+		return
+	}
+	sort.Slice(children, func(i, j int) bool {
+		return strings.Join(children[i].labelValues, "\xff") < strings.Join(children[j].labelValues, "\xff")
+	})
+
+	fmt.Fprintf(w, "# HELP %s %s\n", fam.name, escapeHelp(fam.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", fam.name, fam.typ)
+	for _, c := range children {
+		if fam.typ != histogramType {
+			fmt.Fprintf(w, "%s%s %s\n", fam.name, fam.labelString(c, ""), formatFloat(c.val.load()))
+			continue
+		}
+		var cumulative uint64
+		for i, le := range fam.buckets {
+			cumulative += atomic.LoadUint64(&c.bucketCount[i])
+			fmt.Fprintf(w, "%s_bucket%s %d\n", fam.name, fam.labelString(c, formatFloat(le)), cumulative)
+		}
+		count := atomic.LoadUint64(&c.count)
+		fmt.Fprintf(w, "%s_bucket%s %d\n", fam.name, fam.labelString(c, "+Inf"), count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", fam.name, fam.labelString(c, ""), formatFloat(c.val.load()))
+		fmt.Fprintf(w, "%s_count%s %d\n", fam.name, fam.labelString(c, ""), count)
+	}
+}
+
+// {a="x",b="y"}，le 非空时追加 le 标签；没有标签时为空串
+func (fam *family) labelString(c *child, le string) string {
+	if len(fam.labels) == 0 && le == "" {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range fam.labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=\"%s\"", name, escapeLabel(c.labelValues[i]))
+	}
+	if le != "" {
+		if len(fam.labels) > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "le=\"%s\"", le)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+// *Registry 实现了 http.Handler 接口
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := r.WriteText(w); err != nil {
+		slog.Error("metrics: write error", "err", err)
+	}
+}
+
+// 输出 Default 中所有指标的 http.Handler
+func Handler() http.Handler {
+	return Default
+}
+
+var handleOnce sync.Once
+
+// 在 http.DefaultServeMux 的 /metrics 上注册 Handler，可以重复调用
+// Server 和 Registry 的 HandleHTTP 都会调用，二者在同一进程中时只注册一次
+func HandleHTTP() {
+	handleOnce.Do(func() {
+		http.Handle(defaultMetricsPath, Handler())
+	})
+}
@@ -0,0 +1,107 @@
+package myrpc
+
+import (
+	"MyRPC/metrics"
+	"MyRPC/status"
+	"context"
+	"io"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 指标注册在进程级的 metrics.Default 中，使用单独的服务名避免和其他测试互相影响
+type Metered struct{}
+
+func (Metered) Echo(ctx context.Context, n int, reply *int) error {
+	*reply = n
+	return nil
+}
+
+// 不理会 ctx，超时之后照常返回结果
+func (Metered) Late(ctx context.Context, n int, reply *int) error {
+	<-ctx.Done()
+	*reply = n
+	return nil
+}
+
+func scrapeMetrics(t *testing.T) string {
+	t.Helper()
+	srv := httptest.NewServer(metrics.Handler())
+	defer srv.Close()
+	resp, err := srv.Client().Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	return string(body)
+}
+
+// 取出 series 当前的值，没有输出时为 0
+func metricValue(text, series string) float64 {
+	for _, line := range strings.Split(text, "\n") {
+		if v, ok := strings.CutPrefix(line, series+" "); ok {
+			f, _ := strconv.ParseFloat(v, 64)
+			return f
+		}
+	}
+	return 0
+}
+
+// 指标是进程级的，-count 大于 1 时会累加，因此检查相对于 before 的增量
+// 服务端在响应之后才记录指标，等到所有增量都出现在输出中
+func waitMetrics(t *testing.T, before string, delta map[string]float64) {
+	t.Helper()
+	var text string
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		text = scrapeMetrics(t)
+		done := true
+		for series, d := range delta {
+			if metricValue(text, series)-metricValue(before, series) != d {
+				done = false
+				break
+			}
+		}
+		if done {
+			return
+		}
+	}
+	t.Fatalf("metrics did not change by %v:\n%s", delta, text)
+}
+
+func TestMetricsScrape(t *testing.T) {
+	addr := startTestServer(t, &Server{}, Metered{})
+	client := dialTestClient(t, addr, &Option{HandleTimeout: 50 * time.Millisecond})
+	before := scrapeMetrics(t)
+
+	var reply int
+	for i := 0; i < 2; i++ {
+		if err := client.Call(context.Background(), "Metered.Echo", i, &reply); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 客户端收到的是 DeadlineExceeded，即使服务方法之后返回了 nil，也按 DeadlineExceeded 计数
+	if err := client.Call(context.Background(), "Metered.Late", 1, &reply); status.CodeOf(err) != status.DeadlineExceeded {
+		t.Fatalf("err = %v, want DeadlineExceeded", err)
+	}
+
+	waitMetrics(t, before, map[string]float64{
+		`myrpc_server_requests_total{method="Metered.Echo"}`:                       2,
+		`myrpc_server_requests_total{method="Metered.Late"}`:                       1,
+		`myrpc_server_errors_total{method="Metered.Late",code="DeadlineExceeded"}`: 1,
+		`myrpc_server_errors_total{method="Metered.Echo",code="Unknown"}`:          0,
+		`myrpc_server_request_duration_seconds_count{method="Metered.Echo"}`:       2,
+		`myrpc_server_in_flight_requests{method="Metered.Late"}`:                   0,
+		`myrpc_client_requests_total{method="Metered.Echo"}`:                       2,
+		`myrpc_client_errors_total{method="Metered.Late",code="DeadlineExceeded"}`: 1,
+	})
+}
@@ -0,0 +1,110 @@
+/*
+
+单向调用：适用于审计事件、缓存失效通知等不需要响应的请求
+
+| Header{ServiceMethod, Seq, MsgOneWay} | args |
+
+客户端只分配 Seq，不在 pending 中登记，写出请求后立即返回；
+服务端照常经过拦截器链调用服务方法，但不写响应。服务方法返回的错误只能在服务端看到：
+记录日志并计入 methodType.NumErrors。
+服务方法的签名与普通调用相同，reply 会被丢弃；流式方法不能单向调用。
+单向调用不受客户端 ctx 截止时间的限制，只受服务端 HandleTimeout 的限制。
+
+*/
+
+package myrpc
+
+import (
+	"MyRPC/codec"
+	"MyRPC/metadata"
+	"MyRPC/trace"
+	"context"
+	"time"
+)
+
+// 发送一个单向调用，请求写出后即返回，不等待服务方法执行
+// 返回的 error 只表示请求没有发出去；ctx 中的元数据会随请求一起发送
+// 同样经过通过 Use 设置的拦截器，拦截器拿到的 reply 为 nil
+// 与 Call 一样创建一个 client span，请求写出后结束
+func (client *Client) Notify(ctx context.Context, serviceMethod string, args interface{}, opts ...CallOption) (err error) {
+	ctx, span := trace.Start(ctx, serviceMethod, trace.KindClient)
+	defer func() { span.Finish(err) }()
+
+	interceptors := client.getInterceptors()
+	if len(interceptors) == 0 {
+		return client.notify(ctx, serviceMethod, args, nil, opts...)
+	}
+	return ChainClientInterceptors(interceptors, client.notify)(ctx, serviceMethod, args, nil, opts...)
+}
+
+// 拦截器链的末端，写出单向请求
+func (client *Client) notify(ctx context.Context, serviceMethod string, args, _ interface{}, _ ...CallOption) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	md, err := client.requestMetadata(ctx, serviceMethod, args)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+
+	client.sending.Lock()
+	defer client.sending.Unlock()
+	seq, err := client.nextSeq()
+	if err != nil {
+		return err
+	}
+	header := codec.Header{
+		ServiceMethod: serviceMethod,
+		Seq:           seq,
+		Metadata:      md,
+		Type:          codec.MsgOneWay,
+	}
+	body, err := client.signRequest(&header, args)
+	if err == nil {
+		err = client.cc.Write(&header, body)
+	}
+	// 没有响应，只统计请求是否发出
+	observeClientCall(&Call{ServiceMethod: serviceMethod, Error: err, start: start})
+	return err
+}
+
+// 为单向调用分配序列号，不登记到 pending
+func (client *Client) nextSeq() (uint64, error) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if client.closing || client.shutdown || client.draining {
+		return 0, ErrShutDown
+	}
+	seq := client.seq
+	client.seq++
+	return seq, nil
+}
+
+// 处理单向调用，服务方法的错误只记录日志
+func (svr *Server) handleOneWay(ctx context.Context, sc *serverConn, req *Request) {
+	defer sc.wg.Done()
+	defer sc.removeInflight(req.H.Seq)
+
+	var cancel context.CancelFunc
+	if timeout := sc.opt.HandleTimeout; timeout > 0 {
+		ctx, cancel = context.WithTimeoutCause(ctx, timeout, errHandleTimeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+
+	ctx, span := startServerSpan(ctx, req.H)
+	ctx = metadata.NewIncomingContext(ctx, req.H.Metadata)
+	info := &ServerInfo{
+		ServiceMethod: req.H.ServiceMethod,
+		Metadata:      req.H.Metadata,
+		RemoteAddr:    sc.remoteAddr,
+		Peer:          sc.peer,
+	}
+	_, err := svr.invoke(ctx, req, info)
+	span.Finish(err)
+	if err != nil {
+		sc.logger.Warn("rpc server: one-way call failed", "service_method", req.H.ServiceMethod, "seq", req.H.Seq, "err", err)
+	}
+}
@@ -0,0 +1,109 @@
+package myrpc
+
+import (
+	"MyRPC/codec"
+	"MyRPC/status"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+)
+
+type Audit struct {
+	events chan string
+}
+
+func (a *Audit) Record(ctx context.Context, event string, reply *int) error {
+	a.events <- event
+	*reply = len(event)
+	return nil
+}
+
+func (a *Audit) Reject(ctx context.Context, event string, reply *int) error {
+	return status.New(status.FailedPrecondition, "rejected")
+}
+
+func (a *Audit) wait(t *testing.T, want string) {
+	t.Helper()
+	select {
+	case got := <-a.events:
+		if got != want {
+			t.Fatalf("server recorded %q, want %q", got, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("one-way call %q did not run on the server", want)
+	}
+}
+
+func TestNotify(t *testing.T) {
+	a := &Audit{events: make(chan string, 10)}
+	svr := &Server{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	addr := startTestServer(t, svr, a)
+	for _, ct := range []codec.Type{codec.GobType, codec.JsonType, codec.GobFrameType, codec.JsonFrameType} {
+		client := dialTestClient(t, addr, &Option{CodecType: ct})
+		if err := client.Notify(context.Background(), "Audit.Record", "login"); err != nil {
+			t.Fatal(err)
+		}
+		a.wait(t, "login")
+
+		// 服务方法的错误不返回给客户端，连接照常使用
+		if err := client.Notify(context.Background(), "Audit.Reject", "x"); err != nil {
+			t.Fatal(err)
+		}
+		var reply int
+		if err := client.Call(context.Background(), "Audit.Record", "logout", &reply); err != nil || reply != 6 {
+			t.Fatalf("%s: Call after Notify = %d, %v", ct, reply, err)
+		}
+		a.wait(t, "logout")
+	}
+
+	_, mtype, _ := svr.FindService("Audit.Reject")
+	for deadline := time.Now().Add(2 * time.Second); mtype.NumErrors() != 4; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Audit.Reject NumErrors = %d, want 4", mtype.NumErrors())
+		}
+	}
+}
+
+// 直接读连接：单向调用不会产生任何响应，第一个响应属于之后的普通调用
+func TestNotifyNoResponse(t *testing.T) {
+	a := &Audit{events: make(chan string, 10)}
+	addr := startTestServer(t, &Server{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}, a)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if err := json.NewEncoder(conn).Encode(DefaultOption); err != nil {
+		t.Fatal(err)
+	}
+	cc := codec.NewGobCodec(conn)
+	for _, h := range []*codec.Header{
+		{ServiceMethod: "Audit.Record", Seq: 1, Type: codec.MsgOneWay},
+		{ServiceMethod: "Audit.Reject", Seq: 2, Type: codec.MsgOneWay},
+		{ServiceMethod: "Audit.Record", Seq: 3},
+	} {
+		if err := cc.Write(h, "e"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var h codec.Header
+	var reply int
+	if err := cc.ReadHeader(&h); err != nil {
+		t.Fatal(err)
+	}
+	if err := cc.ReadBody(&reply); err != nil || h.Seq != 3 || h.Error != "" || reply != 1 {
+		t.Fatalf("first response: %+v, reply %d, %v; want the response to seq 3", h, reply, err)
+	}
+	a.wait(t, "e")
+	a.wait(t, "e")
+	_ = conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if err := cc.ReadHeader(&h); err == nil {
+		t.Fatalf("unexpected response %+v", h)
+	}
+}
@@ -0,0 +1,371 @@
+/*
+
+自动重连的客户端
+
+Client 的连接断开后会永久进入 shutdown 状态，之后的调用都返回 ErrShutDown。
+ReconnectingClient 在 Client 之上维护连接：连接断开后按指数退避（带随机抖动）重新拨号并重新握手，
+成功后用新的 Client 替换旧的。断开时已经发出的调用仍然以错误结束，不会自动重发。
+收到服务端的 GoAway 时同样立即重新拨号，旧的 Client 等已经发出的调用结束、连接断开之后再关闭。
+
+连接状态：
+
+	ConnIdle --Connect / 第一次调用--> ConnConnecting --成功--> ConnReady --断开 / GoAway--> ConnConnecting
+	                                        |  ^
+	                                    失败 v  | 退避结束
+	                                 ConnTransientFailure
+
+	Close 之后进入 ConnShutdown
+
+ConnIdle 和 ConnConnecting 状态下的调用等待这次拨号的结果；
+ConnTransientFailure 状态下的调用按 ReconnectPolicy.WaitForReady 立即返回 Unavailable，或者等待重连成功直到 ctx 结束。
+
+*/
+
+package myrpc
+
+import (
+	"MyRPC/status"
+	"context"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+type ConnState int
+
+const (
+	ConnIdle ConnState = iota
+	ConnConnecting
+	ConnReady
+	ConnTransientFailure
+	ConnShutdown
+)
+
+var connStateNames = map[ConnState]string{
+	ConnIdle:             "IDLE",
+	ConnConnecting:       "CONNECTING",
+	ConnReady:            "READY",
+	ConnTransientFailure: "TRANSIENT_FAILURE",
+	ConnShutdown:         "SHUTDOWN",
+}
+
+func (s ConnState) String() string {
+	if name, ok := connStateNames[s]; ok {
+		return name
+	}
+	return "INVALID_STATE"
+}
+
+// 重连的退避参数和断开期间的调用策略，零值字段使用默认值
+type ReconnectPolicy struct {
+	BaseDelay  time.Duration // 第一次重试前的等待时间，默认 1s
+	MaxDelay   time.Duration // 等待时间的上限，默认 2min
+	Multiplier float64       // 每次失败后等待时间的倍数，默认 1.6
+	Jitter     float64       // 等待时间在 [1-Jitter, 1+Jitter] 倍之间随机，默认 0.2
+
+	// false 时 ConnTransientFailure 状态下的调用立即返回 Unavailable；true 时等待重连成功或 ctx 结束
+	WaitForReady bool
+}
+
+var DefaultReconnectPolicy = &ReconnectPolicy{
+	BaseDelay:  time.Second,
+	MaxDelay:   2 * time.Minute,
+	Multiplier: 1.6,
+	Jitter:     0.2,
+}
+
+// 第 attempt 次（从 0 开始）失败之后的等待时间
+func (p *ReconnectPolicy) backoff(attempt int) time.Duration {
+	base, maxDelay, mult, jitter := p.BaseDelay, p.MaxDelay, p.Multiplier, p.Jitter
+	if base <= 0 {
+		base = DefaultReconnectPolicy.BaseDelay
+	}
+	if maxDelay <= 0 {
+		maxDelay = DefaultReconnectPolicy.MaxDelay
+	}
+	if mult < 1 {
+		mult = DefaultReconnectPolicy.Multiplier
+	}
+	if jitter <= 0 || jitter > 1 {
+		jitter = DefaultReconnectPolicy.Jitter
+	}
+	return Backoff(attempt, base, maxDelay, mult, jitter)
+}
+
+// 指数退避：第 attempt 次（从 0 开始）等待 base * mult^attempt，不超过 maxDelay，
+// 再在 [1-jitter, 1+jitter] 倍之间随机。ReconnectPolicy 和 xclient.RetryPolicy 填好各自的默认值后调用
+func Backoff(attempt int, base, maxDelay time.Duration, mult, jitter float64) time.Duration {
+	delay := math.Min(float64(base)*math.Pow(mult, float64(attempt)), float64(maxDelay))
+	delay *= 1 + jitter*(rand.Float64()*2-1)
+	return time.Duration(delay)
+}
+
+type ReconnectingClient struct {
+	dial             newClientFunc
+	network, address string
+	opt              *Option
+	policy           *ReconnectPolicy
+
+	mu           sync.Mutex
+	client       *Client // ConnReady 状态下的连接
+	state        ConnState
+	lastErr      error         // 最近一次拨号失败的原因
+	changed      chan struct{} // 每次状态变化时关闭并替换，等待中的调用据此重新检查状态
+	started      bool          // 重连协程是否已经启动
+	closed       bool
+	stop         chan struct{}
+	interceptors []ClientInterceptor
+	subscribers  map[int]func(ConnState)
+	nextSub      int
+
+	notifyMu sync.Mutex // 保证订阅者按顺序收到状态变化
+}
+
+// 创建时不建立连接，状态为 ConnIdle，第一次调用或 Connect 时开始连接
+// policy 为 nil 时使用 DefaultReconnectPolicy
+func DialReconnecting(network, address string, policy *ReconnectPolicy, opts ...*Option) (*ReconnectingClient, error) {
+	return newReconnectingClient(NewClient, network, address, policy, opts)
+}
+
+// rpcAddr 的格式与 XDial 相同
+func XDialReconnecting(rpcAddr string, policy *ReconnectPolicy, opts ...*Option) (*ReconnectingClient, error) {
+	f, network, addr, opts, err := parseRPCAddr(rpcAddr, opts)
+	if err != nil {
+		return nil, err
+	}
+	return newReconnectingClient(f, network, addr, policy, opts)
+}
+
+func newReconnectingClient(f newClientFunc, network, address string, policy *ReconnectPolicy, opts []*Option) (*ReconnectingClient, error) {
+	opt, err := parseOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	if policy == nil {
+		policy = DefaultReconnectPolicy
+	}
+	return &ReconnectingClient{
+		dial:        f,
+		network:     network,
+		address:     address,
+		opt:         opt,
+		policy:      policy,
+		changed:     make(chan struct{}),
+		stop:        make(chan struct{}),
+		subscribers: make(map[int]func(ConnState)),
+	}, nil
+}
+
+func (rc *ReconnectingClient) State() ConnState {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.state
+}
+
+// 注册状态变化的回调，回调按状态变化的顺序依次调用，不应阻塞，也不能调用 Close；返回的函数用于取消注册
+func (rc *ReconnectingClient) Subscribe(fn func(ConnState)) (unsubscribe func()) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	id := rc.nextSub
+	rc.nextSub++
+	rc.subscribers[id] = fn
+	return func() {
+		rc.mu.Lock()
+		defer rc.mu.Unlock()
+		delete(rc.subscribers, id)
+	}
+}
+
+// 注册拦截器，作用于之后建立的每个连接
+func (rc *ReconnectingClient) Use(interceptors ...ClientInterceptor) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.interceptors = append(rc.interceptors, interceptors...)
+	if rc.client != nil {
+		rc.client.Use(interceptors...)
+	}
+}
+
+// 在后台开始连接，不等待结果
+func (rc *ReconnectingClient) Connect() {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.startLocked()
+}
+
+// 调用方需要持有 rc.mu
+func (rc *ReconnectingClient) startLocked() {
+	if rc.started || rc.closed {
+		return
+	}
+	rc.started = true
+	go rc.run()
+}
+
+// 关闭当前连接并停止重连
+func (rc *ReconnectingClient) Close() error {
+	rc.mu.Lock()
+	if rc.closed {
+		rc.mu.Unlock()
+		return ErrShutDown
+	}
+	rc.closed = true
+	close(rc.stop)
+	client := rc.client
+	rc.client = nil
+	rc.mu.Unlock()
+	rc.setState(ConnShutdown, nil)
+	if client != nil {
+		return client.Close()
+	}
+	return nil
+}
+
+// 重连协程：拨号，成功后等待连接断开，失败后退避，直到 Close
+func (rc *ReconnectingClient) run() {
+	attempt := 0
+	for {
+		rc.setState(ConnConnecting, nil)
+		client, err := dialWithTimeout(rc.dial, rc.network, rc.address, rc.opt)
+		if err != nil {
+			rc.opt.logger().Warn("rpc client: reconnect failed", "address", rc.address, "attempt", attempt, "err", err)
+			rc.setState(ConnTransientFailure, err)
+			select {
+			case <-time.After(rc.policy.backoff(attempt)):
+				attempt++
+				continue
+			case <-rc.stop:
+				return
+			}
+		}
+		attempt = 0
+
+		rc.mu.Lock()
+		if rc.closed {
+			rc.mu.Unlock()
+			_ = client.Close()
+			return
+		}
+		client.Use(rc.interceptors...)
+		rc.client = client
+		rc.mu.Unlock()
+		rc.setState(ConnReady, nil)
+
+		select {
+		case <-client.lost:
+			rc.opt.logger().Warn("rpc client: connection lost, reconnecting", "address", rc.address)
+		case <-client.GoAway():
+			rc.opt.logger().Info("rpc client: server is going away, reconnecting", "address", rc.address)
+			client.Retire()
+		case <-rc.stop:
+			return
+		}
+		rc.mu.Lock()
+		if rc.client == client {
+			rc.client = nil
+		}
+		rc.mu.Unlock()
+	}
+}
+
+// ConnShutdown 之后不再变化
+// 先持有 notifyMu 再修改状态，保证订阅者收到的顺序与状态变化的顺序一致
+func (rc *ReconnectingClient) setState(s ConnState, err error) {
+	rc.notifyMu.Lock()
+	defer rc.notifyMu.Unlock()
+	rc.mu.Lock()
+	if rc.state == s || rc.state == ConnShutdown {
+		rc.mu.Unlock()
+		return
+	}
+	rc.state = s
+	if err != nil {
+		rc.lastErr = err
+	}
+	close(rc.changed)
+	rc.changed = make(chan struct{})
+	subscribers := make([]func(ConnState), 0, len(rc.subscribers))
+	for _, fn := range rc.subscribers {
+		subscribers = append(subscribers, fn)
+	}
+	rc.mu.Unlock()
+
+	for _, fn := range subscribers {
+		fn(s)
+	}
+}
+
+// 按状态和 WaitForReady 返回可用的 Client
+func (rc *ReconnectingClient) getClient(ctx context.Context) (*Client, error) {
+	for {
+		rc.mu.Lock()
+		switch rc.state {
+		case ConnShutdown:
+			rc.mu.Unlock()
+			return nil, ErrShutDown
+		case ConnReady:
+			// 收到 GoAway 或已经断开的 Client 不再使用，等待重连协程替换
+			if client := rc.client; client != nil && client.IsAvailable() {
+				rc.mu.Unlock()
+				return client, nil
+			}
+		case ConnIdle:
+			rc.startLocked()
+		case ConnTransientFailure:
+			if !rc.policy.WaitForReady {
+				err := rc.lastErr
+				rc.mu.Unlock()
+				return nil, status.Errorf(status.Unavailable, "client: %s is unavailable: %v", rc.address, err)
+			}
+		}
+		changed := rc.changed
+		rc.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, status.Errorf(status.Convert(ctx.Err()).Code, "client: waiting for connection to %s: %v", rc.address, ctx.Err())
+		}
+	}
+}
+
+func (rc *ReconnectingClient) Call(ctx context.Context, serviceMethod string, args, reply interface{}, opts ...CallOption) error {
+	client, err := rc.getClient(ctx)
+	if err != nil {
+		return err
+	}
+	return client.Call(ctx, serviceMethod, args, reply, opts...)
+}
+
+func (rc *ReconnectingClient) Notify(ctx context.Context, serviceMethod string, args interface{}, opts ...CallOption) error {
+	client, err := rc.getClient(ctx)
+	if err != nil {
+		return err
+	}
+	return client.Notify(ctx, serviceMethod, args, opts...)
+}
+
+func (rc *ReconnectingClient) Batch(ctx context.Context, calls []*Call) error {
+	client, err := rc.getClient(ctx)
+	if err != nil {
+		return err
+	}
+	return client.Batch(ctx, calls)
+}
+
+func (rc *ReconnectingClient) Stream(ctx context.Context, serviceMethod string, args, reply interface{}) (*ClientStream, error) {
+	client, err := rc.getClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return client.Stream(ctx, serviceMethod, args, reply)
+}
+
+func (rc *ReconnectingClient) NewStream(ctx context.Context, serviceMethod string, args, reply interface{}) (*ClientStream, error) {
+	client, err := rc.getClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return client.NewStream(ctx, serviceMethod, args, reply)
+}
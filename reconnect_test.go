@@ -0,0 +1,238 @@
+package myrpc
+
+import (
+	"MyRPC/status"
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+type Named struct {
+	name    string
+	started chan struct{}
+	release chan struct{}
+}
+
+func (n *Named) Name(ctx context.Context, _ int, reply *string) error {
+	*reply = n.name
+	return nil
+}
+
+// 通知 started，等到 release 关闭之后才返回
+func (n *Named) Wait(ctx context.Context, _ int, reply *string) error {
+	n.started <- struct{}{}
+	<-n.release
+	*reply = n.name
+	return nil
+}
+
+// 在指定地址上启动 svr，地址刚被释放时可能需要重试
+func startTestServerAt(t *testing.T, addr string, svr *Server, rcvrs ...interface{}) {
+	t.Helper()
+	for _, rcvr := range rcvrs {
+		if err := svr.Register(rcvr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var l net.Listener
+	var err error
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if l, err = net.Listen("tcp", addr); err == nil || time.Now().After(deadline) {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	go svr.Accept(l)
+	t.Cleanup(func() { _ = svr.Close() })
+}
+
+// 一个当前没有监听的本地地址
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	_ = l.Close()
+	return addr
+}
+
+var fastReconnect = ReconnectPolicy{BaseDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond}
+
+func dialTestReconnecting(t *testing.T, addr string, waitForReady bool) *ReconnectingClient {
+	t.Helper()
+	policy := fastReconnect
+	policy.WaitForReady = waitForReady
+	rc, err := DialReconnecting("tcp", addr, &policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = rc.Close() })
+	return rc
+}
+
+func callName(rc *ReconnectingClient, method string, timeout time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var name string
+	err := rc.Call(ctx, method, 0, &name)
+	return name, err
+}
+
+// 等到 rc 离开 ConnReady，也就是发现旧连接不能再用
+func waitNotReady(t *testing.T, rc *ReconnectingClient) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); rc.State() == ConnReady; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("State stayed READY")
+		}
+	}
+}
+
+// 记录状态变化的顺序
+type stateRecorder struct {
+	mu     sync.Mutex
+	states []ConnState
+}
+
+func (r *stateRecorder) record(s ConnState) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.states = append(r.states, s)
+}
+
+func (r *stateRecorder) get() []ConnState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]ConnState(nil), r.states...)
+}
+
+func TestReconnectStates(t *testing.T) {
+	addr := startTestServer(t, &Server{}, &Named{name: "a"})
+	rc := dialTestReconnecting(t, addr, false)
+	var rec stateRecorder
+	rc.Subscribe(rec.record)
+
+	if s := rc.State(); s != ConnIdle {
+		t.Fatalf("State = %v before the first call, want IDLE", s)
+	}
+	if name, err := callName(rc, "Named.Name", time.Second); err != nil || name != "a" {
+		t.Fatalf("Name = %q, %v", name, err)
+	}
+	if s := rc.State(); s != ConnReady {
+		t.Fatalf("State = %v, want READY", s)
+	}
+	_ = rc.Close()
+	want := []ConnState{ConnConnecting, ConnReady, ConnShutdown}
+	if got := rec.get(); len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Fatalf("state changes = %v, want %v", got, want)
+	}
+	if _, err := callName(rc, "Named.Name", time.Second); err != ErrShutDown {
+		t.Fatalf("call after Close: err = %v, want ErrShutDown", err)
+	}
+}
+
+func TestReconnectTransientFailure(t *testing.T) {
+	addr := freeAddr(t)
+
+	rc := dialTestReconnecting(t, addr, false)
+	if _, err := callName(rc, "Named.Name", time.Second); status.CodeOf(err) != status.Unavailable {
+		t.Fatalf("err = %v, want Unavailable", err)
+	}
+	if s := rc.State(); s != ConnTransientFailure && s != ConnConnecting {
+		t.Fatalf("State = %v, want TRANSIENT_FAILURE or CONNECTING", s)
+	}
+
+	// WaitForReady 时等到 ctx 结束，服务端启动之后重连成功
+	rc = dialTestReconnecting(t, addr, true)
+	if _, err := callName(rc, "Named.Name", 100*time.Millisecond); status.CodeOf(err) != status.DeadlineExceeded {
+		t.Fatalf("err = %v, want DeadlineExceeded", err)
+	}
+	startTestServerAt(t, addr, &Server{}, &Named{name: "a"})
+	if name, err := callName(rc, "Named.Name", 2*time.Second); err != nil || name != "a" {
+		t.Fatalf("Name = %q, %v", name, err)
+	}
+}
+
+func TestReconnectAfterRestart(t *testing.T) {
+	first := &Server{}
+	addr := startTestServer(t, first, &Named{name: "first"})
+	rc := dialTestReconnecting(t, addr, true)
+	if name, err := callName(rc, "Named.Name", time.Second); err != nil || name != "first" {
+		t.Fatalf("Name = %q, %v", name, err)
+	}
+
+	_ = first.Close()
+	waitNotReady(t, rc)
+	startTestServerAt(t, addr, &Server{}, &Named{name: "second"})
+	if name, err := callName(rc, "Named.Name", 2*time.Second); err != nil || name != "second" {
+		t.Fatalf("Name after restart = %q, %v", name, err)
+	}
+	if s := rc.State(); s != ConnReady {
+		t.Fatalf("State = %v, want READY", s)
+	}
+}
+
+// 服务端优雅关闭时连接在处理完请求之前不会断开，收到 GoAway 就要切换到新的连接
+func TestReconnectGoAway(t *testing.T) {
+	first := &Server{}
+	old := &Named{name: "first", started: make(chan struct{}, 1), release: make(chan struct{})}
+	addr := startTestServer(t, first, old)
+	rc := dialTestReconnecting(t, addr, true)
+	if _, err := callName(rc, "Named.Name", time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// 一个正在处理的调用让旧连接保持打开
+	waited := make(chan error, 1)
+	go func() {
+		name, err := callName(rc, "Named.Wait", 5*time.Second)
+		if err == nil && name != "first" {
+			err = status.Errorf(status.Internal, "Wait answered by %q", name)
+		}
+		waited <- err
+	}()
+	select {
+	case <-old.started:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Wait did not reach the server")
+	}
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- first.Shutdown(context.Background()) }()
+	waitNotReady(t, rc)
+	startTestServerAt(t, addr, &Server{}, &Named{name: "second"})
+	if name, err := callName(rc, "Named.Name", 2*time.Second); err != nil || name != "second" {
+		t.Fatalf("Name after GoAway = %q, %v; want second", name, err)
+	}
+
+	// GoAway 之前发出的调用在旧连接上正常结束
+	close(old.release)
+	if err := <-waited; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-shutdown; err != nil {
+		t.Fatal(err)
+	}
+	if name, err := callName(rc, "Named.Name", time.Second); err != nil || name != "second" {
+		t.Fatalf("Name = %q, %v", name, err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempt, want := range []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond, 50 * time.Millisecond, 50 * time.Millisecond} {
+		if got := Backoff(attempt, 10*time.Millisecond, 50*time.Millisecond, 2, 0); got != want {
+			t.Errorf("Backoff(%d) = %v, want %v", attempt, got, want)
+		}
+		for i := 0; i < 20; i++ {
+			if got := Backoff(attempt, 10*time.Millisecond, 50*time.Millisecond, 2, 0.2); got < want*8/10 || got > want*12/10 {
+				t.Fatalf("Backoff(%d) with jitter = %v, want within 20%% of %v", attempt, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,204 @@
+/*
+
+反射服务：让客户端或工具在运行时查询服务端提供了哪些服务和方法，以及参数和返回值的结构
+
+内置服务名为 "_Reflection"，不需要注册，第一次被调用时创建（可以通过 Server.DisableReflection 关闭）：
+
+	_Reflection.ListServices(struct{}, *ListServicesReply)         所有服务及其方法名
+	_Reflection.DescribeMethod(string "Service.Method", *MethodDesc)  参数和返回值的类型描述
+
+类型描述是 JSON Schema 风格的 TypeSchema，字段名取 json tag，与 JsonCodec 和网关使用的编码一致，
+方便 CLI 或网关在没有编译期类型的情况下构造请求。
+
+*/
+
+package myrpc
+
+import (
+	"MyRPC/status"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+const ReflectionServiceName = "_Reflection"
+
+// 框架内置的服务，ListServices 和调试页面据此与用户注册的服务区分开
+func isBuiltinService(name string) bool {
+	return name == ReflectionServiceName
+}
+
+type ServiceDesc struct {
+	Name    string   `json:"name"`
+	Methods []string `json:"methods"`
+}
+
+type ListServicesReply struct {
+	Services []ServiceDesc `json:"services"`
+}
+
+type MethodDesc struct {
+	Name   string      `json:"name"`   // Service.Method
+	Stream bool        `json:"stream"` // 流式方法，Reply 为流中每条消息的类型，未知时为 nil
+	Args   *TypeSchema `json:"args"`
+	Reply  *TypeSchema `json:"reply,omitempty"`
+}
+
+// JSON Schema 风格的类型描述
+// Type 取值：object / array / string / integer / number / boolean，为空表示任意类型（interface）
+type TypeSchema struct {
+	Type                 string                 `json:"type,omitempty"`
+	GoType               string                 `json:"goType,omitempty"` // Go 中的类型名，如 main.Args、[]int
+	Format               string                 `json:"format,omitempty"` // 如 int64、date-time、byte
+	Nullable             bool                   `json:"nullable,omitempty"`
+	Properties           map[string]*TypeSchema `json:"properties,omitempty"`
+	Items                *TypeSchema            `json:"items,omitempty"`                // array 的元素类型
+	AdditionalProperties *TypeSchema            `json:"additionalProperties,omitempty"` // map 的 value 类型
+	Ref                  string                 `json:"$ref,omitempty"`                 // 递归引用，值为外层对象的 GoType
+}
+
+// 反射服务的实现，方法签名与普通服务相同
+type reflection struct {
+	svr *Server
+}
+
+func (r *reflection) ListServices(_ struct{}, reply *ListServicesReply) error {
+	r.svr.ServiceMap.Range(func(key, value interface{}) bool {
+		svc := value.(*service)
+		if isBuiltinService(svc.name) {
+			return true
+		}
+		desc := ServiceDesc{Name: svc.name}
+		for name := range svc.method {
+			desc.Methods = append(desc.Methods, name)
+		}
+		sort.Strings(desc.Methods)
+		reply.Services = append(reply.Services, desc)
+		return true
+	})
+	sort.Slice(reply.Services, func(i, j int) bool {
+		return reply.Services[i].Name < reply.Services[j].Name
+	})
+	return nil
+}
+
+func (r *reflection) DescribeMethod(serviceMethod string, reply *MethodDesc) error {
+	_, mtype, err := r.svr.FindService(serviceMethod)
+	if err != nil {
+		return err
+	}
+	reply.Name = serviceMethod
+	reply.Stream = mtype.isStream
+	reply.Args = typeSchema(mtype.ArgType, nil)
+	if !mtype.isStream {
+		reply.Reply = typeSchema(mtype.ReplyType.Elem(), nil)
+	}
+	return nil
+}
+
+// 反射服务的 service，服务名不是导出的标识符，不能通过 Register 注册
+func (svr *Server) reflectionService() (*service, error) {
+	if svr.DisableReflection {
+		return nil, status.Errorf(status.NotFound, "server: can't find service %s", ReflectionServiceName)
+	}
+	if s, ok := svr.ServiceMap.Load(ReflectionServiceName); ok {
+		return s.(*service), nil
+	}
+	s := &service{
+		name: ReflectionServiceName,
+		rcvr: reflect.ValueOf(&reflection{svr: svr}),
+	}
+	s.typ = s.rcvr.Type()
+	s.registerMethods(svr.logger())
+	actual, _ := svr.ServiceMap.LoadOrStore(ReflectionServiceName, s)
+	return actual.(*service), nil
+}
+
+var (
+	typeOfTime     = reflect.TypeOf(time.Time{})
+	typeOfDuration = reflect.TypeOf(time.Duration(0))
+	typeOfRawJSON  = reflect.TypeOf(json.RawMessage(nil))
+)
+
+// 生成 t 的类型描述；seen 记录外层正在展开的结构体，遇到递归类型时用 Ref 引用
+func typeSchema(t reflect.Type, seen map[reflect.Type]bool) *TypeSchema {
+	nullable := false
+	for t.Kind() == reflect.Ptr {
+		t, nullable = t.Elem(), true
+	}
+	s := &TypeSchema{GoType: t.String(), Nullable: nullable}
+	switch {
+	case t == typeOfTime:
+		s.Type, s.Format = "string", "date-time"
+		return s
+	case t == typeOfDuration:
+		s.Type, s.Format = "integer", "int64"
+		return s
+	case t == typeOfRawJSON:
+		return s
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		s.Type = "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		s.Type, s.Format = "integer", t.Kind().String()
+	case reflect.Float32, reflect.Float64:
+		s.Type, s.Format = "number", t.Kind().String()
+	case reflect.String:
+		s.Type = "string"
+	case reflect.Slice, reflect.Array:
+		if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 { // []byte 在 json 中编码为 base64 字符串
+			s.Type, s.Format = "string", "byte"
+			break
+		}
+		s.Type = "array"
+		s.Items = typeSchema(t.Elem(), seen)
+	case reflect.Map:
+		s.Type = "object"
+		s.AdditionalProperties = typeSchema(t.Elem(), seen)
+	case reflect.Struct:
+		s.Type = "object"
+		if seen[t] {
+			s.Ref = t.String()
+			return s
+		}
+		if seen == nil {
+			seen = make(map[reflect.Type]bool)
+		}
+		seen[t] = true
+		s.Properties = make(map[string]*TypeSchema)
+		structProperties(t, seen, s.Properties)
+		delete(seen, t)
+	}
+	return s
+}
+
+// 按 encoding/json 的规则收集导出字段，匿名结构体字段没有 json tag 时展开到外层
+func structProperties(t reflect.Type, seen map[reflect.Type]bool, props map[string]*TypeSchema) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			structProperties(ft, seen, props)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = typeSchema(f.Type, seen)
+	}
+}
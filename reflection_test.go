@@ -0,0 +1,120 @@
+package myrpc
+
+import (
+	"MyRPC/status"
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type ReflNote struct {
+	Note string `json:"note"`
+}
+
+type ReflArgs struct {
+	ID     int64 `json:"id"`
+	Tags   []string
+	When   time.Time
+	Scores map[string]float64 `json:"scores,omitempty"`
+	Secret string             `json:"-"`
+	hidden int
+	*ReflNote
+	Next *ReflArgs `json:"next"`
+}
+
+type Catalog struct{}
+
+func (Catalog) Get(args ReflArgs, reply *[]byte) error {
+	return nil
+}
+
+func (Catalog) Watch(ctx context.Context, args ReflArgs, stream *ServerStream) error {
+	return nil
+}
+
+func TestReflectionListServices(t *testing.T) {
+	addr := startTestServer(t, &Server{}, Catalog{}, Arith{})
+	client := dialTestClient(t, addr, nil)
+
+	var reply ListServicesReply
+	if err := client.Call(context.Background(), "_Reflection.ListServices", struct{}{}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	want := []ServiceDesc{
+		{Name: "Arith", Methods: []string{"Sum"}},
+		{Name: "Catalog", Methods: []string{"Get", "Watch"}},
+	}
+	if !reflect.DeepEqual(reply.Services, want) {
+		t.Fatalf("ListServices = %+v, want %+v", reply.Services, want)
+	}
+}
+
+func TestReflectionDescribeMethod(t *testing.T) {
+	addr := startTestServer(t, &Server{}, Catalog{})
+	client := dialTestClient(t, addr, nil)
+	describe := func(method string) (*MethodDesc, error) {
+		var desc MethodDesc
+		err := client.Call(context.Background(), "_Reflection.DescribeMethod", method, &desc)
+		return &desc, err
+	}
+
+	desc, err := describe("Catalog.Get")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc.Stream || desc.Reply == nil || desc.Reply.Type != "string" || desc.Reply.Format != "byte" {
+		t.Fatalf("Catalog.Get reply = %+v", desc.Reply)
+	}
+	props := desc.Args.Properties
+	if desc.Args.Type != "object" || desc.Args.GoType != "myrpc.ReflArgs" {
+		t.Fatalf("args = %+v", desc.Args)
+	}
+	for name, want := range map[string]TypeSchema{
+		"id":     {Type: "integer", Format: "int64", GoType: "int64"},
+		"When":   {Type: "string", Format: "date-time", GoType: "time.Time"},
+		"note":   {Type: "string", GoType: "string"}, // 匿名字段展开到外层
+		"next":   {Type: "object", GoType: "myrpc.ReflArgs", Nullable: true, Ref: "myrpc.ReflArgs"},
+		"Tags":   {Type: "array", GoType: "[]string"},
+		"scores": {Type: "object", GoType: "map[string]float64"},
+	} {
+		got, ok := props[name]
+		if !ok {
+			t.Errorf("property %s missing", name)
+			continue
+		}
+		shallow := *got
+		shallow.Items, shallow.AdditionalProperties = nil, nil
+		if !reflect.DeepEqual(shallow, want) {
+			t.Errorf("property %s = %+v, want %+v", name, shallow, want)
+		}
+	}
+	if items := props["Tags"].Items; items == nil || items.Type != "string" {
+		t.Errorf("Tags items = %+v", items)
+	}
+	if values := props["scores"].AdditionalProperties; values == nil || values.Type != "number" || values.Format != "float64" {
+		t.Errorf("scores values = %+v", values)
+	}
+	for _, name := range []string{"Secret", "hidden", "ReflNote"} {
+		if _, ok := props[name]; ok {
+			t.Errorf("property %s should not be described", name)
+		}
+	}
+
+	desc, err = describe("Catalog.Watch")
+	if err != nil || !desc.Stream || desc.Reply != nil {
+		t.Fatalf("Catalog.Watch = %+v, %v; want a stream without a reply schema", desc, err)
+	}
+	if _, err := describe("Catalog.Missing"); status.CodeOf(err) != status.NotFound {
+		t.Fatalf("Catalog.Missing: err = %v, want NotFound", err)
+	}
+}
+
+func TestReflectionDisabled(t *testing.T) {
+	addr := startTestServer(t, &Server{DisableReflection: true}, Catalog{})
+	client := dialTestClient(t, addr, nil)
+	var reply ListServicesReply
+	if err := client.Call(context.Background(), "_Reflection.ListServices", struct{}{}, &reply); status.CodeOf(err) != status.NotFound {
+		t.Fatalf("err = %v, want NotFound", err)
+	}
+}
@@ -9,7 +9,11 @@
 package registry
 
 import (
+	"MyRPC/metrics"
+	"crypto/tls"
+	"fmt"
 	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"sort"
@@ -23,10 +27,22 @@ const (
 	defaultTimeout = time.Minute * 5
 )
 
+var (
+	aliveServers = metrics.NewGaugeVec("myrpc_registry_alive_servers",
+		"Servers whose last heartbeat is within the timeout.")
+	heartbeats = metrics.NewCounterVec("myrpc_registry_heartbeats_total",
+		"Heartbeats received from servers.")
+)
+
+// Heartbeat / Deregister 使用的 http 客户端，registry 使用 https 时在这里配置 TLS
+var HTTPClient = http.DefaultClient
+
 type Registry struct {
 	timeout time.Duration
 	mu      sync.Mutex // 为下面的 map 服务
 	servers map[string]*ServerItem
+
+	Logger *slog.Logger // nil 时使用 slog.Default()；通过 NewRegistry 创建时使用 WithLogger 设置
 }
 
 type ServerItem struct {
@@ -34,12 +50,35 @@ type ServerItem struct {
 	startTime time.Time
 }
 
-func NewRegistry() string {
+// NewRegistry 的可选参数，在 registry 开始服务之前生效
+type Option func(*Registry)
+
+func WithLogger(logger *slog.Logger) Option {
+	return func(r *Registry) {
+		r.Logger = logger
+	}
+}
+
+func NewRegistry(opts ...Option) string {
+	return newRegistry(nil, opts)
+}
+
+// 与 NewRegistry 相同，但 HTTP API 使用 TLS，返回 https:// 开头的地址
+func NewRegistryTLS(config *tls.Config, opts ...Option) string {
+	return newRegistry(config, opts)
+}
+
+func newRegistry(config *tls.Config, opts []Option) string {
 	// 强制使用IPv4避免Windows上的IPv6问题
 	l, err := net.Listen("tcp4", ":8088")
 	if err != nil {
 		log.Fatal("registry: failed to listen:", err)
 	}
+	scheme := "http://"
+	if config != nil {
+		l = tls.NewListener(l, config)
+		scheme = "https://"
+	}
 
 	registryAddr := l.Addr().String()
 	// 确保地址格式正确
@@ -48,10 +87,13 @@ func NewRegistry() string {
 	}
 
 	registry := New(defaultTimeout)
+	for _, opt := range opts {
+		opt(registry)
+	}
 	registry.HandleHTTP(defaultPath)
 
 	go func() {
-		log.Printf("Registry HTTP server starting on %s", registryAddr)
+		registry.logger().Info("registry: http server starting", "addr", registryAddr)
 		_ = http.Serve(l, nil)
 	}()
 	go func() { // 定时检测心跳
@@ -62,8 +104,8 @@ func NewRegistry() string {
 	}()
 	time.Sleep(time.Second)
 
-	fullURL := "http://" + registryAddr + defaultPath
-	log.Printf("Registry started at: %s", fullURL)
+	fullURL := scheme + registryAddr + defaultPath
+	registry.logger().Info("registry: started", "url", fullURL)
 	return fullURL
 }
 
@@ -87,14 +129,26 @@ func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
+		heartbeats.WithLabelValues().Inc()
+		r.logger().Debug("registry: heartbeat", "server", addr)
 		r.putServer(addr)
+	case "DELETE": // server 关闭时主动注销
+		addr := req.Header.Get("X-rpc-servers")
+		if addr == "" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		r.logger().Info("registry: server deregistered", "server", addr)
+		r.removeServer(addr)
 	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
 }
 
+// 同时在 /metrics 上输出指标
 func (r *Registry) HandleHTTP(registryPath string) {
 	http.Handle(registryPath, r) // 路由注册；尚未启动持续监听
+	metrics.HandleHTTP()
 }
 
 // 增加注册的进程 / 更新服务进程的启动时间
@@ -103,10 +157,19 @@ func (r *Registry) putServer(addr string) {
 	defer r.mu.Unlock()
 	s := r.servers[addr]
 	if s == nil {
+		r.logger().Info("registry: server registered", "server", addr)
 		r.servers[addr] = &ServerItem{Addr: addr, startTime: time.Now()}
 	} else {
 		s.startTime = time.Now()
 	}
+	r.updateAliveGauge()
+}
+
+func (r *Registry) removeServer(addr string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.servers, addr)
+	r.updateAliveGauge()
 }
 
 // 获取所有 alive 的服务进程
@@ -118,37 +181,87 @@ func (r *Registry) getAliveServers() []string {
 		if r.timeout == 0 || s.startTime.Add(r.timeout).After(time.Now()) {
 			ret = append(ret, addr)
 		} else {
+			r.logger().Info("registry: server expired", "server", addr, "last_heartbeat", s.startTime)
 			delete(r.servers, addr)
 		}
 	}
 	sort.Strings(ret)
+	aliveServers.WithLabelValues().Set(float64(len(ret)))
 	return ret
 }
 
+func (r *Registry) logger() *slog.Logger {
+	if r.Logger != nil {
+		return r.Logger
+	}
+	return slog.Default()
+}
+
+// 调用方需要持有 r.mu
+func (r *Registry) updateAliveGauge() {
+	n := 0
+	for _, s := range r.servers {
+		if r.timeout == 0 || s.startTime.Add(r.timeout).After(time.Now()) {
+			n++
+		}
+	}
+	aliveServers.WithLabelValues().Set(float64(n))
+}
+
 // 为 server 提供，用于 server 定期向 Registry 发送心跳
-func Heartbeat(registry, addr string, duration time.Duration) {
+// 返回的 stop 用于停止心跳，可以重复调用；logger 为 nil 时使用 slog.Default()
+func Heartbeat(registry, addr string, duration time.Duration, logger *slog.Logger) (stop func()) {
 	if duration == 0 {
 		duration = defaultTimeout - time.Duration(1)*time.Minute // 将 1 转换为 time.Duration 类型
 	}
-	sendHeartbeat(registry, addr)
+	if logger == nil {
+		logger = slog.Default()
+	}
+	sendHeartbeat(logger, registry, addr)
+	done := make(chan struct{})
 	go func() {
-		t := time.Tick(duration)
-		for _ = range t {
-			sendHeartbeat(registry, addr)
+		t := time.NewTicker(duration)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				sendHeartbeat(logger, registry, addr)
+			case <-done:
+				return
+			}
 		}
 	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
+
+// 为 server 提供，关闭时从 Registry 注销，不必等到心跳超时
+func Deregister(registry, addr string) error {
+	req, _ := http.NewRequest("DELETE", registry, nil)
+	req.Header.Set("X-rpc-servers", addr)
+	rsp, err := HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return fmt.Errorf("registry: deregister %s: %s", addr, rsp.Status)
+	}
+	return nil
 }
 
 // 起一个 http 客户端，发送心跳
-func sendHeartbeat(registry, addr string) error {
-	log.Println(addr, "sendHeartbeat to", registry)
-	httpClient := &http.Client{}
+func sendHeartbeat(logger *slog.Logger, registry, addr string) error {
+	logger.Debug("registry: send heartbeat", "server", addr, "registry", registry)
 	req, _ := http.NewRequest("POST", registry, nil)
 	req.Header.Set("X-rpc-servers", addr)
-	_, err := httpClient.Do(req)
+	rsp, err := HTTPClient.Do(req)
 	if err != nil {
-		log.Println("server: send heartbeat error", err)
+		logger.Warn("registry: send heartbeat error", "server", addr, "registry", registry, "err", err)
 		return err
 	}
+	_ = rsp.Body.Close()
 	return nil
 }
@@ -18,11 +18,19 @@ package myrpc
 
 import (
 	"MyRPC/codec"
+	"MyRPC/metadata"
 	"MyRPC/registry"
+	"MyRPC/status"
+	"MyRPC/trace"
+	"bufio"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"net"
 	"reflect"
 	"strings"
@@ -40,6 +48,18 @@ type Option struct {
 	// 超时处理参数
 	ConnectionTimeout time.Duration
 	HandleTimeout     time.Duration
+
+	// 流式调用每个方向的初始发送额度（消息条数），0 表示使用默认值 64
+	StreamWindow int
+
+	// 客户端的日志输出，nil 时使用 slog.Default()，不参与握手
+	Logger *slog.Logger `json:"-"`
+	// 非 nil 时客户端使用 TLS 建立连接，见 tls.go
+	TLSConfig *tls.Config `json:"-"`
+
+	// 认证相关，见 auth.go：AuthMetadata 随握手发送一次，Credentials 为每个请求提供凭证
+	AuthMetadata metadata.MD `json:",omitempty"`
+	Credentials  Credentials `json:"-"`
 }
 
 var DefaultOption = &Option{
@@ -53,16 +73,70 @@ var DefaultOption = &Option{
 type Server struct {
 	ServiceMap sync.Map
 	Address    string
+
+	// 为 true 时服务方法中的 panic 不会被恢复，直接导致进程退出，方便调试时拿到完整的现场
+	DisableRecovery bool
+
+	// 为 true 时不提供内置的反射服务（_Reflection），见 reflection.go
+	DisableReflection bool
+
+	// 日志输出，nil 时使用 slog.Default()；通过 NewServer 创建时使用 WithLogger 设置
+	Logger *slog.Logger
+	// 为 true 时每个请求处理结束后以 Info 级别记录一条访问日志
+	AccessLog bool
+
+	// 非 nil 时 Accept 在收到的连接上进行 TLS 握手，传给 Accept 的不应再是 TLS listener，见 tls.go
+	TLSConfig *tls.Config
+
+	// 非 nil 时每个请求在进入拦截器链之前先经过认证，见 auth.go
+	// 需要在 Accept 之前设置，通过 NewServer 创建时使用 WithAuthenticator
+	Authenticator Authenticator
+	// 非 nil 时认证之后按方法检查调用方的权限，决策写入 AuditLogger（nil 时使用 Logger），见 acl.go
+	// 与 Authenticator 一样需要在 Accept 之前设置，通过 NewServer 创建时使用 WithACL / WithAuditLogger
+	ACL         *ACL
+	AuditLogger *slog.Logger
+
+	mu            sync.Mutex
+	interceptors  []ServerInterceptor
+	inShutdown    bool
+	listeners     map[net.Listener]struct{}
+	conns         map[io.Closer]*serverConn // 握手阶段的连接 value 为 nil
+	registryAddr  string                    // 非空时表示注册到了 registry，关闭时需要注销
+	serverAddr    string                    // 注册到 registry 的地址，tcp@ 或 tls@ 开头
+	stopHeartbeat func()
+}
+
+// NewServer 的可选参数，在 server 开始 Accept 之前设置好，避免与正在处理的请求竞争
+type ServerOption func(*Server)
+
+// 日志输出，同时用于向 registry 发送心跳
+func WithLogger(logger *slog.Logger) ServerOption {
+	return func(svr *Server) {
+		svr.Logger = logger
+	}
+}
+
+func WithAccessLog(enabled bool) ServerOption {
+	return func(svr *Server) {
+		svr.AccessLog = enabled
+	}
+}
+
+func NewServer(registryAddr string, svr chan *Server, opts ...ServerOption) {
+	newServer(registryAddr, nil, svr, opts)
 }
 
-func NewServer(registryAddr string, svr chan *Server) {
+func newServer(registryAddr string, config *tls.Config, svr chan *Server, opts []ServerOption) {
 	// 在Windows上强制使用IPv4地址避免IPv6连接问题
 	l, err := net.Listen("tcp4", ":0")
 	if err != nil {
 		log.Fatal("server: failed to listen:", err)
 	}
 
-	server := Server{}
+	server := Server{TLSConfig: config}
+	for _, opt := range opts {
+		opt(&server)
+	}
 	// 获取实际的监听地址
 	addr := l.Addr().String()
 	// 确保地址格式正确，将 0.0.0.0 替换为 127.0.0.1 用于客户端连接
@@ -72,21 +146,25 @@ func NewServer(registryAddr string, svr chan *Server) {
 		addr = "127.0.0.1" + addr
 	}
 	serverAddr := "tcp@" + addr
+	if config != nil {
+		serverAddr = "tls@" + addr
+	}
 
 	// 初始化服务器地址
 	server.Address = addr
 
-	log.Printf("Server starting at: %s", serverAddr)
+	server.logger().Info("rpc server: starting", "addr", serverAddr)
 
 	// 新起的 server 定期向 registry 发送心跳
-	registry.Heartbeat(registryAddr, serverAddr, 0)
+	server.registryAddr, server.serverAddr = registryAddr, serverAddr
+	server.stopHeartbeat = registry.Heartbeat(registryAddr, serverAddr, 0, server.logger())
 	svr <- &server
-	server.Accept(l)
+	server.Accept(l) // Shutdown / Close 之后返回
 }
 
 // 注册服务到 sync.Map 中
 func (svr *Server) Register(rcvr interface{}) error {
-	s := newService(rcvr) // rcvr 类似于 AuthServiceImpl，是一个绑定了若干 rpc 方法的结构体
+	s := newService(rcvr, svr.logger()) // rcvr 类似于 AuthServiceImpl，是一个绑定了若干 rpc 方法的结构体
 	_, isDup := svr.ServiceMap.LoadOrStore(s.name, s)
 	if isDup {
 		return errors.New("server: service already exist" + s.name)
@@ -97,34 +175,50 @@ func (svr *Server) Register(rcvr interface{}) error {
 func (server *Server) FindService(serviceMethod string) (svc *service, mtype *methodType, err error) {
 	dotIdx := strings.LastIndex(serviceMethod, ".")
 	if dotIdx < 0 {
-		err = errors.New("server: wrong service.method format")
+		err = status.New(status.InvalidArgument, "server: wrong service.method format")
 		return
 	}
 	serviceName, methodName := serviceMethod[:dotIdx], serviceMethod[dotIdx+1:]
-	serviceStruct, ok := server.ServiceMap.Load(serviceName)
-	if !ok {
-		err = errors.New("server: cann't find service")
-		return
+	if serviceName == ReflectionServiceName {
+		if svc, err = server.reflectionService(); err != nil {
+			return
+		}
+	} else {
+		serviceStruct, ok := server.ServiceMap.Load(serviceName)
+		if !ok {
+			err = status.Errorf(status.NotFound, "server: can't find service %s", serviceName)
+			return
+		}
+		svc = serviceStruct.(*service)
 	}
-	svc = serviceStruct.(*service)
 	mtype = svc.method[methodName]
 	if mtype == nil {
-		err = errors.New("server: can't find method")
+		err = status.Errorf(status.NotFound, "server: can't find method %s", serviceMethod)
 		return
 	}
 	return
 }
 
 func (svr *Server) Accept(lis net.Listener) {
+	if !svr.trackListener(lis) {
+		_ = lis.Close()
+		return
+	}
+	defer svr.untrackListener(lis)
 	// 每轮循环建立一个与新的客户端的连接
 	for {
 		// socket 通过 Accept() 得到
 		conn, err := lis.Accept() // 阻塞等待新的客户端的连接，返回一个新的 conn
 		if err != nil {
-			log.Println("rpc server: accept error:", err)
+			if !svr.shuttingDown() {
+				svr.logger().Error("rpc server: accept error", "err", err)
+			}
 			return
 		}
 
+		if svr.TLSConfig != nil {
+			conn = tls.Server(conn, svr.TLSConfig)
+		}
 		go svr.ServeConn(conn)
 	}
 }
@@ -134,48 +228,177 @@ func (svr *Server) ServeConn(conn io.ReadWriteCloser) {
 	defer func() {
 		_ = conn.Close()
 	}()
+	if !svr.trackConn(conn, nil) {
+		return
+	}
+	defer svr.untrackConn(conn)
+
+	var remoteAddr string
+	if nc, ok := conn.(net.Conn); ok {
+		remoteAddr = nc.RemoteAddr().String()
+	}
+	logger := svr.logger().With("remote_addr", remoteAddr)
 
-	// option 字段是使用 json 序列化的
+	// option 字段是使用 json 序列化的，TLS 连接在第一次读时完成握手
 	var opt Option
-	if err := json.NewDecoder(conn).Decode(&opt); err != nil {
-		log.Println("rpc server: option decode error:", err)
+	dec := json.NewDecoder(conn)
+	if err := dec.Decode(&opt); err != nil {
+		logger.Warn("rpc server: option decode error", "err", err)
 		return
 	}
 	if opt.MagicNumber != MagicNumber {
-		log.Printf("rpc sever: invalid magic number %x", opt.MagicNumber)
+		logger.Warn("rpc server: invalid magic number", "magic_number", fmt.Sprintf("%x", opt.MagicNumber))
 		return
 	}
 
 	// 拿到 Codec 的构造函数
 	f := codec.NewCodecFuncMap[opt.CodecType]
 	if f == nil {
-		log.Printf("rpc server: invalid codec type")
+		logger.Warn("rpc server: invalid codec type", "codec", opt.CodecType)
+		return
+	}
+	// 握手完成后改由 serveCodec 以 Codec 为单位登记
+	svr.untrackConn(conn)
+	codecType := string(opt.CodecType)
+	mc := newMeteredConn(newHandshakeConn(conn, dec.Buffered()), serverBytesIn.WithLabelValues(codecType), serverBytesOut.WithLabelValues(codecType))
+	peer := newPeer(conn)
+	peer.AuthMetadata = opt.AuthMetadata
+	svr.serveCodec(f(mc), &opt, peer)
+}
+
+// json.Decoder 读 option 时会预读，可能把紧随其后的 Header/Body 也读进了它的缓冲区
+// 这里把缓冲区里剩下的字节拼回连接的最前面，交给 Codec 继续读
+// 另外 json.Encoder 会在 option 后面追加一个换行符，需要跳过，否则 gob 会把它当成消息长度
+// 只跳过缓冲区里的第一个字节：缓冲区为空时换行符不一定存在，连接上的下一个字节属于 Codec
+type handshakeConn struct {
+	io.Reader
+	io.Writer
+	io.Closer
+}
+
+func newHandshakeConn(conn io.ReadWriteCloser, buffered io.Reader) io.ReadWriteCloser {
+	br := bufio.NewReader(buffered)
+	if b, err := br.Peek(1); err == nil && b[0] == '\n' {
+		_, _ = br.Discard(1)
+	}
+	return &handshakeConn{
+		Reader: bufio.NewReader(io.MultiReader(br, conn)),
+		Writer: conn,
+		Closer: conn,
 	}
-	svr.serveCodec(f(conn), &opt)
 }
 
 var invalidRequest = struct{}{} // 出错时的空占位符
 
+// 一个客户端连接的处理状态
+type serverConn struct {
+	cc      codec.Codec
+	opt     *Option
+	sending sync.Mutex // 与客户端一一对应，保证 response 不会发生并发混乱
+	writers int32      // 正在等待或正在写响应的协程数，见 sendResponse
+	wg      sync.WaitGroup
+
+	mu       sync.Mutex
+	inflight map[uint64]context.CancelFunc // 正在处理的请求，收到客户端的取消帧时取消对应的 ctx
+	draining bool                          // 已通知客户端停止发送新请求，之后到达的请求直接拒绝
+	streams  map[uint64]*ServerStream      // 正在进行的流式调用，读循环据此分发数据帧和流控制帧
+
+	peer       *Peer
+	remoteAddr string
+	logger     *slog.Logger // 带有 remote_addr 字段
+}
+
+var errServerDraining = status.New(status.Unavailable, "server: server is shutting down")
+
+// 登记一个即将处理的请求；连接处于 draining 状态时返回 false
+// 与 goAway 在同一把锁下判断，保证 goAway 之后不会再有 wg.Add
+func (sc *serverConn) addInflight(seq uint64, cancel context.CancelFunc) bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.draining {
+		return false
+	}
+	sc.inflight[seq] = cancel
+	sc.wg.Add(1)
+	return true
+}
+
+func (sc *serverConn) removeInflight(seq uint64) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	delete(sc.inflight, seq)
+}
+
+func (sc *serverConn) cancelInflight(seq uint64) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if cancel := sc.inflight[seq]; cancel != nil {
+		cancel()
+	}
+}
+
 // 一个客户端的可能会连续发送多个请求
-// 处理每个客户端请求的主体逻辑，使用与客户端一一对应的 Mutex 保证 response 不会发生并发混乱
-func (svr *Server) serveCodec(cc codec.Codec, opt *Option) {
-	sending := new(sync.Mutex)
-	wg := new(sync.WaitGroup)
+// 处理每个客户端请求的主体逻辑
+func (svr *Server) serveCodec(cc codec.Codec, opt *Option, peer *Peer) {
+	sc := &serverConn{
+		cc:         cc,
+		opt:        opt,
+		peer:       peer,
+		remoteAddr: peer.Addr,
+		logger:     svr.logger().With("remote_addr", peer.Addr),
+		inflight:   make(map[uint64]context.CancelFunc),
+		streams:    make(map[uint64]*ServerStream),
+	}
+	if !svr.trackConn(cc, sc) {
+		_ = cc.Close()
+		return
+	}
+	defer svr.untrackConn(cc)
+	serverConnsTotal.WithLabelValues().Inc()
+	serverConns.WithLabelValues().Inc()
+	defer serverConns.WithLabelValues().Dec()
+	// 读取出错（通常是客户端断开连接）时取消，通知所有正在处理的请求
+	ctx, cancel := context.WithCancel(newPeerContext(context.Background(), peer))
 	for {
-		req, err := svr.readRequest(cc)
+		req, err := svr.readRequest(sc)
 		if err != nil {
 			if req == nil {
 				break
 			}
-			req.H.Error = err.Error()
-			svr.sendResponse(cc, req.H, invalidRequest, sending)
+			svr.sendError(sc, req, err)
+			continue
+		}
+		switch req.H.Type {
+		case codec.MsgCancel:
+			sc.cancelInflight(req.H.Seq)
+			continue
+		case codec.MsgStreamData:
+			continue
+		case codec.MsgStreamHalfClose, codec.MsgWindowUpdate:
+			sc.handleStreamControl(req.H)
 			continue
 		}
-		wg.Add(1)
-		go svr.handleRequest(cc, req, sending, wg, opt.HandleTimeout)
+		// 在启动处理协程之前登记，保证紧随其后的取消帧一定能找到这个请求
+		reqCtx, reqCancel := context.WithCancel(ctx)
+		if !sc.addInflight(req.H.Seq, reqCancel) {
+			reqCancel()
+			svr.sendError(sc, req, errServerDraining)
+			continue
+		}
+		switch {
+		case req.H.Type == codec.MsgOneWay:
+			go svr.handleOneWay(reqCtx, sc, req)
+		case req.Mtype.isStream:
+			// 同样要在启动协程之前登记，紧随其后的数据帧才能找到这个流
+			stream := sc.newStream(req, req.H.Type == codec.MsgCall)
+			go svr.handleStream(reqCtx, sc, req, stream)
+		default:
+			go svr.handleRequest(reqCtx, sc, req)
+		}
 	}
 
-	wg.Wait()
+	cancel()
+	sc.wg.Wait()
 	_ = cc.Close()
 }
 
@@ -184,95 +407,240 @@ type Request struct {
 	argv, replyv reflect.Value
 	Mtype        *methodType
 	Svc          *service
+	rawBody      codec.RawBody // H.RawBody 为 true 时 body 在连接上的原始字节，交给 Authenticator 验证签名
 }
 
 // 最终目标是取得 argv 类型的指针，供 cc.ReadBody() 使用
-func (svr *Server) readRequest(cc codec.Codec) (*Request, error) {
-	h, err := svr.readRequestHeader(cc)
+func (svr *Server) readRequest(sc *serverConn) (*Request, error) {
+	cc := sc.cc
+	h, err := svr.readRequestHeader(sc)
 	if err != nil {
 		return nil, err
 	}
 	req := &Request{H: h}
+	switch h.Type {
+	case codec.MsgCall, codec.MsgStreamOpen, codec.MsgOneWay:
+	case codec.MsgStreamData:
+		// 流中的消息直接放入对应流的队列
+		return req, sc.readStreamData(h)
+	default:
+		// 控制帧没有需要解码的 body
+		return req, cc.ReadBody(nil)
+	}
 	req.Svc, req.Mtype, err = svr.FindService(h.ServiceMethod)
+	switch {
+	case err != nil:
+	case h.Type == codec.MsgStreamOpen && !req.Mtype.isStream:
+		err = status.Errorf(status.InvalidArgument, "server: %s is not a streaming method", h.ServiceMethod)
+	case h.Type == codec.MsgOneWay && req.Mtype.isStream:
+		err = status.Errorf(status.InvalidArgument, "server: streaming method %s cannot be called one-way", h.ServiceMethod)
+	}
 	if err != nil {
+		// 仍然需要把 body 读掉，否则它会被当成下一个 header
+		_ = cc.ReadBody(nil)
 		return req, err
 	}
 
 	// 基于 server 端 map 中存储的 method 信息拿到参数和返回值信息
 	req.argv = req.Mtype.NewArgv()
-	req.replyv = req.Mtype.NewReplyv()
+	if !req.Mtype.isStream { // 流式方法的 replyv 在 handleStream 中创建
+		req.replyv = req.Mtype.NewReplyv()
+	}
 
 	argvi := req.argv.Interface() // 通过 reflect.Value 获取原始值（空）
 	if req.argv.Type().Kind() != reflect.Ptr {
 		argvi = req.argv.Addr().Interface() // 转为指针
 	}
 
-	err = cc.ReadBody(argvi)
+	if h.RawBody {
+		err = readRawBody(sc, req, argvi)
+	} else {
+		err = cc.ReadBody(argvi)
+	}
 	if err != nil {
-		log.Println("server: code.Codec ReadBody() wrong")
-		return req, err
+		sc.logger.Warn("rpc server: read body error", "service_method", h.ServiceMethod, "seq", h.Seq, "err", err)
+		return req, status.Errorf(status.InvalidArgument, "server: read body: %v", err)
 	}
 
 	return req, nil
 }
 
-func (svr *Server) readRequestHeader(cc codec.Codec) (*codec.Header, error) {
+// 保留 body 的原始字节再解码
+func readRawBody(sc *serverConn, req *Request, argvi interface{}) error {
+	rc, ok := sc.cc.(codec.RawBodyCodec)
+	if !ok {
+		return fmt.Errorf("codec %s does not support raw bodies", sc.opt.CodecType)
+	}
+	raw, err := rc.ReadRawBody()
+	if err != nil {
+		return err
+	}
+	req.rawBody = raw
+	return rc.UnmarshalBody(raw, argvi)
+}
+
+func (svr *Server) readRequestHeader(sc *serverConn) (*codec.Header, error) {
 	var H codec.Header
-	if err := cc.ReadHeader(&H); err != nil {
-		if err != io.EOF && err != io.ErrUnexpectedEOF {
-			log.Println("rpc server, read header error:", err)
+	if err := sc.cc.ReadHeader(&H); err != nil {
+		if err != io.EOF && err != io.ErrUnexpectedEOF && !errors.Is(err, net.ErrClosed) {
+			sc.logger.Warn("rpc server: read header error", "err", err)
 		}
 		return nil, err
 	}
 	return &H, nil
 }
 
-func (svr *Server) handleRequest(cc codec.Codec, req *Request, sending *sync.Mutex, wg *sync.WaitGroup, timeout time.Duration) {
-	defer wg.Done()
+var (
+	errHandleTimeout  = status.New(status.DeadlineExceeded, "server: execute method timeout")
+	errClientDeadline = status.New(status.DeadlineExceeded, "server: client deadline exceeded")
+)
 
-	called := make(chan struct{}, 1)
-	sent := make(chan struct{}, 1)
+// ctx 在连接断开或收到客户端的取消帧时被取消
+func (svr *Server) handleRequest(ctx context.Context, sc *serverConn, req *Request) {
+	defer sc.wg.Done()
+	defer sc.removeInflight(req.H.Seq)
+
+	// 超时时取消 ctx，使用 context 签名的服务方法可以据此提前结束
+	// 客户端的剩余时间比 HandleTimeout 更短时以客户端为准，此时客户端已经放弃等待，不需要再写响应
+	var cancel context.CancelFunc
+	timeout := sc.opt.HandleTimeout
+	switch {
+	case req.H.Timeout > 0 && (timeout == 0 || req.H.Timeout < timeout):
+		ctx, cancel = context.WithTimeoutCause(ctx, req.H.Timeout, errClientDeadline)
+	case timeout > 0:
+		ctx, cancel = context.WithTimeoutCause(ctx, timeout, errHandleTimeout)
+	default:
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+
+	// 请求元数据通过 ctx 交给服务方法，服务方法设置的响应元数据随响应头返回
+	ctx, span := startServerSpan(ctx, req.H)
+	ctx = metadata.NewIncomingContext(ctx, req.H.Metadata)
+	ctx = metadata.NewResponseContext(ctx)
+	info := &ServerInfo{
+		ServiceMethod: req.H.ServiceMethod,
+		Metadata:      req.H.Metadata,
+		RemoteAddr:    sc.remoteAddr,
+		Peer:          sc.peer,
+	}
+	req.H.Metadata = nil
 
-	var isTimeout uint32
-	atomic.StoreUint32(&isTimeout, 0) // 写入（对其他 goroutine 可见）
+	called := make(chan struct{})
+
+	// 处理协程和超时分支通过 CAS 抢占 responded，抢到的一方负责写响应，另一方不再访问 req.H
+	var responded uint32
 
 	go func() {
-		err := req.Svc.call(req.Mtype, req.argv, req.replyv)
-		isTimeoutVal := atomic.LoadUint32(&isTimeout)
-		if isTimeoutVal == 1 {
+		defer close(called)
+		reply, err := svr.invoke(ctx, req, info)
+		span.Finish(err)
+		if !atomic.CompareAndSwapUint32(&responded, 0, 1) {
 			return
 		}
-		called <- struct{}{}
+		req.H.Metadata = metadata.ResponseFromContext(ctx)
 		if err != nil {
-			req.H.Error = err.Error()
-			svr.sendResponse(cc, req.H, invalidRequest, sending)
-			sent <- struct{}{}
+			setHeaderError(req.H, err)
+			svr.sendResponse(sc, req.H, invalidRequest)
 			return
 		}
-		svr.sendResponse(cc, req.H, req.replyv.Interface(), sending)
-		sent <- struct{}{}
+		svr.sendResponse(sc, req.H, reply)
 	}()
 
-	if timeout == 0 {
-		<-called
-		<-sent
-		return
-	}
 	select {
-	case <-time.After(timeout):
-		atomic.StoreUint32(&isTimeout, 1)
-		req.H.Error = "server: execute method timeout"
-		svr.sendResponse(cc, req.H, invalidRequest, sending)
+	case <-ctx.Done():
+		if !atomic.CompareAndSwapUint32(&responded, 0, 1) {
+			// 服务方法恰好已经返回，正在写响应
+			<-called
+			return
+		}
+		// 连接已经断开、客户端取消或客户端已超时，都不需要再写响应
+		if context.Cause(ctx) == errHandleTimeout {
+			setHeaderError(req.H, errHandleTimeout)
+			svr.sendResponse(sc, req.H, invalidRequest)
+		}
 	case <-called:
-		<-sent
 	}
 }
 
+func (svr *Server) logger() *slog.Logger {
+	if svr.Logger != nil {
+		return svr.Logger
+	}
+	return slog.Default()
+}
+
+func (opt *Option) logger() *slog.Logger {
+	if opt != nil && opt.Logger != nil {
+		return opt.Logger
+	}
+	return slog.Default()
+}
+
+// 访问日志，由 invoke 在请求处理结束时调用
+func (svr *Server) logAccess(req *Request, info *ServerInfo, latency time.Duration, err error) {
+	attrs := []any{
+		"service_method", info.ServiceMethod,
+		"seq", req.H.Seq,
+		"remote_addr", info.RemoteAddr,
+		"latency", latency,
+		"code", status.CodeOf(err).String(),
+	}
+	if err != nil {
+		attrs = append(attrs, "err", err)
+	}
+	svr.logger().Info("rpc access", attrs...)
+}
+
+// 以请求头中上游的 traceparent 为父节点创建 server span，放进返回的 ctx
+func startServerSpan(ctx context.Context, h *codec.Header) (context.Context, *trace.Span) {
+	if sc, ok := trace.Extract(h.Metadata); ok {
+		ctx = trace.ContextWithRemoteSpanContext(ctx, sc)
+	}
+	return trace.Start(ctx, h.ServiceMethod, trace.KindServer)
+}
+
+// 请求无法处理时回复错误；单向调用没有响应，只记录日志
+func (svr *Server) sendError(sc *serverConn, req *Request, err error) {
+	h := req.H
+	serverErrors.WithLabelValues(requestMethod(req), status.CodeOf(err).String()).Inc()
+	if h.Type == codec.MsgOneWay {
+		sc.logger.Warn("rpc server: one-way call dropped", "service_method", h.ServiceMethod, "seq", h.Seq, "err", err)
+		return
+	}
+	setHeaderError(h, err)
+	svr.sendResponse(sc, h, invalidRequest)
+}
+
+// 将 err 转为错误码写入响应头，普通的 error 为 Unknown
+func setHeaderError(h *codec.Header, err error) {
+	st := status.Convert(err)
+	h.Error = st.Message
+	h.Code = uint32(st.Code)
+	h.Details = st.Details
+}
+
 // 将传入的 rsp header 和 rsp body 作为 rsp 写入到 conn
-func (svr *Server) sendResponse(cc codec.Codec, H *codec.Header, body interface{}, sending *sync.Mutex) {
-	sending.Lock()
-	defer sending.Unlock()
-	if err := cc.Write(H, body); err != nil {
-		log.Println("rpc server: write response error:", err)
+// 多个响应同时等待写出时（如客户端一次发来一批请求）合并 Flush：
+// 拿到锁之前先登记，写完后如果还有人在排队就只写入缓冲区，由最后一个写完的负责 Flush
+func (svr *Server) sendResponse(sc *serverConn, H *codec.Header, body interface{}) {
+	bw, ok := sc.cc.(codec.BufferedWriter)
+	atomic.AddInt32(&sc.writers, 1)
+	sc.sending.Lock()
+	defer sc.sending.Unlock()
+	var err error
+	if !ok {
+		atomic.AddInt32(&sc.writers, -1)
+		err = sc.cc.Write(H, body)
+	} else {
+		err = bw.WriteBuffered(H, body)
+		if atomic.AddInt32(&sc.writers, -1) == 0 {
+			if flushErr := bw.Flush(); err == nil {
+				err = flushErr
+			}
+		}
+	}
+	if err != nil {
+		sc.logger.Error("rpc server: write response error", "service_method", H.ServiceMethod, "seq", H.Seq, "err", err)
 	}
 }
@@ -0,0 +1,350 @@
+package myrpc
+
+import (
+	"MyRPC/codec"
+	"MyRPC/status"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 在本地随机端口上启动 svr，注册 rcvrs，测试结束时关闭；svr.Address 设置为监听的地址
+func startTestServer(t *testing.T, svr *Server, rcvrs ...interface{}) string {
+	t.Helper()
+	for _, rcvr := range rcvrs {
+		if err := svr.Register(rcvr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svr.Address = l.Addr().String()
+	go svr.Accept(l)
+	t.Cleanup(func() { _ = svr.Close() })
+	return svr.Address
+}
+
+func dialTestClient(t *testing.T, addr string, opt *Option) *Client {
+	t.Helper()
+	client, err := Dial("tcp", addr, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+type ArithArgs struct {
+	A, B int
+}
+
+type Arith struct{}
+
+// 不带 context 的方法签名
+func (Arith) Sum(args ArithArgs, reply *int) error {
+	*reply = args.A + args.B
+	return nil
+}
+
+func TestCallCodecs(t *testing.T) {
+	addr := startTestServer(t, &Server{}, Arith{})
+	for _, ct := range []codec.Type{codec.GobType, codec.JsonType, codec.GobFrameType, codec.JsonFrameType} {
+		client := dialTestClient(t, addr, &Option{CodecType: ct})
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				var reply int
+				if err := client.Call(context.Background(), "Arith.Sum", ArithArgs{A: i, B: i * i}, &reply); err != nil || reply != i+i*i {
+					t.Errorf("%s: Sum(%d, %d) = %d, %v", ct, i, i*i, reply, err)
+				}
+			}(i)
+		}
+		wg.Wait()
+	}
+}
+
+type readWriteCloser struct {
+	io.Reader
+	io.Writer
+	io.Closer
+}
+
+// 只跳过 json.Decoder 缓冲区里的换行符，连接上读到的字节原样交给 Codec
+func TestHandshakeConn(t *testing.T) {
+	for _, tc := range []struct {
+		buffered, conn, want string
+	}{
+		{"\nab", "c", "abc"},
+		{"ab", "c", "abc"},
+		{"", "\nabc", "\nabc"},
+		{"", "abc", "abc"},
+	} {
+		conn := newHandshakeConn(readWriteCloser{Reader: strings.NewReader(tc.conn)}, strings.NewReader(tc.buffered))
+		got, err := io.ReadAll(conn)
+		if err != nil || string(got) != tc.want {
+			t.Errorf("buffered %q, conn %q: read %q, %v; want %q", tc.buffered, tc.conn, got, err, tc.want)
+		}
+	}
+}
+
+func TestBatch(t *testing.T) {
+	addr := startTestServer(t, &Server{}, Arith{})
+	for _, ct := range []codec.Type{codec.GobType, codec.JsonType, codec.GobFrameType, codec.JsonFrameType} {
+		client := dialTestClient(t, addr, &Option{CodecType: ct})
+		calls := make([]*Call, 0, 21)
+		for i := 0; i < 20; i++ {
+			calls = append(calls, &Call{ServiceMethod: "Arith.Sum", Args: ArithArgs{A: i, B: 1}, Reply: new(int)})
+		}
+		// 一个调用失败不影响同一批的其他调用
+		calls = append(calls[:10], append([]*Call{{ServiceMethod: "Arith.Missing", Args: ArithArgs{}, Reply: new(int)}}, calls[10:]...)...)
+		if err := client.Batch(context.Background(), calls); err != nil {
+			t.Fatalf("%s: Batch = %v", ct, err)
+		}
+		for i, call := range calls {
+			switch {
+			case i == 10:
+				if status.CodeOf(call.Error) != status.NotFound {
+					t.Errorf("%s: Arith.Missing: err = %v, want NotFound", ct, call.Error)
+				}
+			case call.Error != nil:
+				t.Errorf("%s: call %d: %v", ct, i, call.Error)
+			default:
+				want := i + 1
+				if i > 10 {
+					want = i
+				}
+				if got := *call.Reply.(*int); got != want {
+					t.Errorf("%s: call %d = %d, want %d", ct, i, got, want)
+				}
+			}
+		}
+	}
+}
+
+// 参数无法编码的调用返回错误，整批调用都能结束
+func TestBatchEncodeError(t *testing.T) {
+	addr := startTestServer(t, &Server{}, Arith{})
+	for _, ct := range []codec.Type{codec.GobType, codec.JsonType, codec.GobFrameType, codec.JsonFrameType} {
+		client := dialTestClient(t, addr, &Option{CodecType: ct})
+		// 放在最后：后面没有其他消息，写了一半的请求会让服务端一直等下去
+		calls := []*Call{
+			{ServiceMethod: "Arith.Sum", Args: ArithArgs{A: 1, B: 2}, Reply: new(int)},
+			{ServiceMethod: "Arith.Sum", Args: ArithArgs{A: 3, B: 4}, Reply: new(int)},
+			{ServiceMethod: "Arith.Sum", Args: make(chan int), Reply: new(int)},
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		err := client.Batch(ctx, calls)
+		cancel()
+		if err != nil {
+			t.Fatalf("%s: Batch = %v", ct, err)
+		}
+		if calls[2].Error == nil {
+			t.Errorf("%s: call with an unencodable argument succeeded", ct)
+		}
+		// 分帧的 Codec 在编码失败时什么都不写，其他调用不受影响
+		if ct == codec.GobFrameType || ct == codec.JsonFrameType {
+			for _, i := range []int{0, 1} {
+				if calls[i].Error != nil {
+					t.Errorf("%s: call %d: %v", ct, i, calls[i].Error)
+				}
+			}
+		}
+	}
+}
+
+// 分帧的 Codec 上参数解码失败只影响这一个调用，连接可以继续使用
+func TestFrameBadBody(t *testing.T) {
+	addr := startTestServer(t, &Server{}, Arith{})
+	for _, ct := range []codec.Type{codec.GobFrameType, codec.JsonFrameType} {
+		client := dialTestClient(t, addr, &Option{CodecType: ct})
+		var reply int
+		if err := client.Call(context.Background(), "Arith.Sum", "not args", &reply); status.CodeOf(err) != status.InvalidArgument {
+			t.Fatalf("%s: err = %v, want InvalidArgument", ct, err)
+		}
+		if err := client.Call(context.Background(), "Arith.Sum", ArithArgs{A: 1, B: 2}, &reply); err != nil || reply != 3 {
+			t.Fatalf("%s: Sum after a bad body = %d, %v", ct, reply, err)
+		}
+	}
+}
+
+type Sleeper struct {
+	started chan struct{}
+	stopped chan error
+}
+
+func newSleeper() *Sleeper {
+	return &Sleeper{started: make(chan struct{}, 1), stopped: make(chan error, 1)}
+}
+
+// 一直阻塞到 ctx 被取消，把取消的原因发给 stopped
+func (s *Sleeper) Block(ctx context.Context, _ int, reply *int) error {
+	s.started <- struct{}{}
+	<-ctx.Done()
+	s.stopped <- ctx.Err()
+	return ctx.Err()
+}
+
+func (s *Sleeper) waitStopped(t *testing.T, want error) {
+	t.Helper()
+	select {
+	case err := <-s.stopped:
+		if err != want {
+			t.Fatalf("handler ctx ended with %v, want %v", err, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler ctx was not cancelled")
+	}
+}
+
+func TestHandleTimeout(t *testing.T) {
+	s := newSleeper()
+	addr := startTestServer(t, &Server{}, s)
+	client := dialTestClient(t, addr, &Option{HandleTimeout: 50 * time.Millisecond})
+
+	var reply int
+	if err := client.Call(context.Background(), "Sleeper.Block", 0, &reply); status.CodeOf(err) != status.DeadlineExceeded {
+		t.Fatalf("err = %v, want DeadlineExceeded", err)
+	}
+	s.waitStopped(t, context.DeadlineExceeded)
+}
+
+func TestDisconnectCancelsHandler(t *testing.T) {
+	s := newSleeper()
+	addr := startTestServer(t, &Server{}, s)
+	client := dialTestClient(t, addr, nil)
+
+	call := client.Go("Sleeper.Block", 0, new(int), nil)
+	<-s.started
+	_ = client.Close()
+	s.waitStopped(t, context.Canceled)
+	<-call.Done
+}
+
+// 返回 ctx 的剩余时间，没有截止时间时返回 -1
+func (s *Sleeper) Deadline(ctx context.Context, _ int, reply *time.Duration) error {
+	*reply = -1
+	if deadline, ok := ctx.Deadline(); ok {
+		*reply = time.Until(deadline)
+	}
+	return nil
+}
+
+func TestClientDeadline(t *testing.T) {
+	addr := startTestServer(t, &Server{}, newSleeper())
+	client := dialTestClient(t, addr, nil)
+
+	var left time.Duration
+	if err := client.Call(context.Background(), "Sleeper.Deadline", 0, &left); err != nil || left != -1 {
+		t.Fatalf("Deadline without a client deadline = %v, %v", left, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Call(ctx, "Sleeper.Deadline", 0, &left); err != nil || left <= 0 || left > 5*time.Second {
+		t.Fatalf("Deadline = %v, %v; want within (0, 5s]", left, err)
+	}
+}
+
+func TestCancelFrame(t *testing.T) {
+	s := newSleeper()
+	addr := startTestServer(t, &Server{}, s)
+	client := dialTestClient(t, addr, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- client.Call(ctx, "Sleeper.Block", 0, new(int)) }()
+	<-s.started
+	cancel()
+	if err := <-errc; status.CodeOf(err) != status.Canceled {
+		t.Fatalf("err = %v, want Canceled", err)
+	}
+	s.waitStopped(t, context.Canceled)
+
+	// 取消一个调用不影响同一个连接上之后的调用
+	var left time.Duration
+	if err := client.Call(context.Background(), "Sleeper.Deadline", 0, &left); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type Panicky struct{}
+
+func (Panicky) Boom(ctx context.Context, msg string, reply *int) error {
+	panic(msg)
+}
+
+func (Panicky) Fail(ctx context.Context, msg string, reply *int) error {
+	return status.New(status.FailedPrecondition, msg)
+}
+
+func TestPanicRecovery(t *testing.T) {
+	svr := &Server{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	addr := startTestServer(t, svr, Panicky{})
+	client := dialTestClient(t, addr, nil)
+
+	for i := 0; i < 2; i++ {
+		if err := client.Call(context.Background(), "Panicky.Boom", "kaboom", new(int)); status.CodeOf(err) != status.Internal || !strings.Contains(err.Error(), "kaboom") {
+			t.Fatalf("err = %v, want Internal", err)
+		}
+	}
+	// panic 不影响连接，返回 error 同样计入 NumErrors
+	if err := client.Call(context.Background(), "Panicky.Fail", "no", new(int)); status.CodeOf(err) != status.FailedPrecondition {
+		t.Fatalf("err = %v, want FailedPrecondition", err)
+	}
+
+	for method, want := range map[string]uint64{"Panicky.Boom": 2, "Panicky.Fail": 1} {
+		_, mtype, err := svr.FindService(method)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mtype.NumCalls() != want || mtype.NumErrors() != want {
+			t.Errorf("%s: NumCalls = %d, NumErrors = %d; want %d", method, mtype.NumCalls(), mtype.NumErrors(), want)
+		}
+	}
+}
+
+type Failer struct{}
+
+// 按 kind 返回不同形式的错误
+func (Failer) Fail(kind string, reply *int) error {
+	switch kind {
+	case "status":
+		return status.New(status.NotFound, "no such user").WithDetails("field", "user_id")
+	case "wrapped":
+		return fmt.Errorf("lookup: %w", status.New(status.PermissionDenied, "not yours"))
+	default:
+		return errors.New("plain failure")
+	}
+}
+
+func TestStatusErrors(t *testing.T) {
+	addr := startTestServer(t, &Server{}, Failer{})
+	for _, ct := range []codec.Type{codec.GobType, codec.JsonType, codec.GobFrameType, codec.JsonFrameType} {
+		client := dialTestClient(t, addr, &Option{CodecType: ct})
+		for kind, want := range map[string]status.Error{
+			"status": {Code: status.NotFound, Message: "no such user", Details: map[string]string{"field": "user_id"}},
+			// 包装过的 *status.Error 以其中的错误码和信息返回
+			"wrapped": {Code: status.PermissionDenied, Message: "not yours"},
+			"plain":   {Code: status.Unknown, Message: "plain failure"},
+		} {
+			err := client.Call(context.Background(), "Failer.Fail", kind, new(int))
+			var st *status.Error
+			if !errors.As(err, &st) {
+				t.Fatalf("%s %s: %T %v is not a *status.Error", ct, kind, err, err)
+			}
+			if status.CodeOf(err) != want.Code || st.Message != want.Message || st.Details["field"] != want.Details["field"] {
+				t.Errorf("%s %s: got %+v, want %+v", ct, kind, st, want)
+			}
+		}
+	}
+}
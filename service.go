@@ -1,23 +1,75 @@
 package myrpc
 
 import (
+	"context"
 	"go/ast"
 	"log"
+	"log/slog"
 	"reflect"
+	"sort"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
 type methodType struct {
-	method    reflect.Method
-	ArgType   reflect.Type
-	ReplyType reflect.Type
-	numCalls  uint64 // 用于统计方法调用次数
+	method     reflect.Method
+	ArgType    reflect.Type
+	ReplyType  reflect.Type
+	numCalls   uint64 // 用于统计方法调用次数
+	numErrors  uint64 // 返回 error 或发生 panic 的次数
+	hasContext bool   // 方法的第一个参数是否为 context.Context
+	isStream   bool   // 服务端流式方法，ReplyType 为 *ServerStream
+	latency    latencyWindow
 }
 
 func (m *methodType) NumCalls() uint64 {
 	return atomic.LoadUint64(&m.numCalls) // 安全地读取 numCalls 的值
 }
 
+func (m *methodType) NumErrors() uint64 {
+	return atomic.LoadUint64(&m.numErrors)
+}
+
+func (m *methodType) IsStream() bool {
+	return m.isStream
+}
+
+// 最近 latencySamples 次调用的耗时（流式方法为整个流的持续时间），用于计算分位数
+const latencySamples = 1024
+
+type latencyWindow struct {
+	mu      sync.Mutex
+	samples [latencySamples]time.Duration
+	n       int // 已记录的总次数，下一个样本写入 samples[n%latencySamples]
+}
+
+func (w *latencyWindow) observe(d time.Duration) {
+	w.mu.Lock()
+	w.samples[w.n%latencySamples] = d
+	w.n++
+	w.mu.Unlock()
+}
+
+// 返回 qs 中每个分位数（0~1）对应的耗时，还没有调用时全为 0
+func (w *latencyWindow) percentiles(qs ...float64) []time.Duration {
+	w.mu.Lock()
+	n := min(w.n, latencySamples)
+	sorted := make([]time.Duration, n)
+	copy(sorted, w.samples[:n])
+	w.mu.Unlock()
+
+	res := make([]time.Duration, len(qs))
+	if n == 0 {
+		return res
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	for i, q := range qs {
+		res[i] = sorted[min(int(q*float64(n)), n-1)]
+	}
+	return res
+}
+
 // 创建 ArgType 所在类型的值（实例）
 func (m *methodType) NewArgv() reflect.Value {
 	var argv reflect.Value
@@ -47,7 +99,7 @@ type service struct {
 	method map[string]*methodType // 注意 methodType 结构体
 }
 
-func newService(rcvr interface{}) *service {
+func newService(rcvr interface{}, logger *slog.Logger) *service {
 	s := new(service)
 	// 将结构体解析为 service
 	s.rcvr = reflect.ValueOf(rcvr)
@@ -56,32 +108,50 @@ func newService(rcvr interface{}) *service {
 	if !ast.IsExported(s.name) {
 		log.Fatalf("rpc server: %s is not a valid service name", s.name)
 	}
-	s.registerMethods()
+	s.registerMethods(logger)
 	return s
 }
 
+var (
+	typeOfError   = reflect.TypeOf((*error)(nil)).Elem()
+	typeOfContext = reflect.TypeOf((*context.Context)(nil)).Elem()
+)
+
 // 组装好完整的 methodType 结构体
-func (s *service) registerMethods() {
+// 支持以下方法签名：
+//
+//	func (T) M(args, *reply) error
+//	func (T) M(ctx context.Context, args, *reply) error
+//	func (T) M(ctx context.Context, args, stream *ServerStream) error  // 服务端流式，见 stream.go
+func (s *service) registerMethods(logger *slog.Logger) {
 	s.method = make(map[string]*methodType)
 	for i := 0; i < s.typ.NumMethod(); i++ {
 		method := s.typ.Method(i) // 这里 method 是 reflect.Method
 		mType := method.Type
-		if mType.NumIn() != 3 || mType.NumOut() != 1 {
+		if mType.NumOut() != 1 || mType.Out(0) != typeOfError {
 			continue
 		}
-		if mType.Out(0) != reflect.TypeOf((*error)(nil)).Elem() {
+		// 第 0 个参数是接收者
+		argIdx, hasContext := 1, false
+		switch {
+		case mType.NumIn() == 3:
+		case mType.NumIn() == 4 && mType.In(1) == typeOfContext:
+			argIdx, hasContext = 2, true
+		default:
 			continue
 		}
-		argType, replyType := mType.In(1), mType.In(2)
+		argType, replyType := mType.In(argIdx), mType.In(argIdx+1)
 		if !isExportedOrBuiltinType(argType) || !isExportedOrBuiltinType(replyType) {
 			continue
 		}
 		s.method[method.Name] = &methodType{
-			method:    method,
-			ArgType:   argType,
-			ReplyType: replyType,
+			method:     method,
+			ArgType:    argType,
+			ReplyType:  replyType,
+			hasContext: hasContext,
+			isStream:   replyType == typeOfServerStream,
 		}
-		log.Printf("rpc server: register %s.%s\n", s.name, method.Name)
+		logger.Info("rpc server: register method", "service_method", s.name+"."+method.Name)
 	}
 }
 
@@ -89,14 +159,27 @@ func isExportedOrBuiltinType(t reflect.Type) bool {
 	return ast.IsExported(t.Name()) || t.PkgPath() == ""
 }
 
-func (s *service) call(m *methodType, argv, replyv reflect.Value) error {
+// 服务方法中的 panic 不在这里恢复，由 Server.invoke 统一处理
+func (s *service) call(ctx context.Context, m *methodType, argv, replyv reflect.Value) (err error) {
 	atomic.AddUint64(&m.numCalls, 1)
+	returned := false
+	start := time.Now()
+	defer func() {
+		m.latency.observe(time.Since(start))
+		if err != nil || !returned { // !returned 说明发生了 panic
+			atomic.AddUint64(&m.numErrors, 1)
+		}
+	}()
 	f := m.method.Func
-	returnValues := f.Call([]reflect.Value{s.rcvr, argv, replyv}) // 这里的 call 的第一个参数是结构体（方法绑定到了结构体）
+	in := []reflect.Value{s.rcvr, argv, replyv} // 这里的 call 的第一个参数是结构体（方法绑定到了结构体）
+	if m.hasContext {
+		in = []reflect.Value{s.rcvr, reflect.ValueOf(ctx), argv, replyv}
+	}
+	returnValues := f.Call(in)
+	returned = true
 	// 远程调用不应该有非 nil 的返回值
-	err := returnValues[0].Interface()
-	if err != nil {
-		return err.(error)
+	if errv := returnValues[0].Interface(); errv != nil {
+		return errv.(error)
 	}
 	return nil
 }
@@ -0,0 +1,154 @@
+/*
+
+服务端的关闭
+
+Shutdown(ctx) 优雅关闭：
+1. 关闭所有 listener，不再接受新连接
+2. 停止心跳并从 registry 注销，客户端刷新服务列表后不会再选到这个 server
+3. 向每个连接发送 GoAway 帧，客户端收到后不再在这个连接上发起新的调用
+4. 等待所有正在处理的请求（serverConn.wg）结束，最多等到 ctx 超时
+5. 关闭所有连接
+
+Close() 直接关闭，不等待正在处理的请求
+
+*/
+
+package myrpc
+
+import (
+	"MyRPC/codec"
+	"MyRPC/registry"
+	"context"
+	"io"
+	"net"
+)
+
+func (svr *Server) shuttingDown() bool {
+	svr.mu.Lock()
+	defer svr.mu.Unlock()
+	return svr.inShutdown
+}
+
+func (svr *Server) trackListener(lis net.Listener) bool {
+	svr.mu.Lock()
+	defer svr.mu.Unlock()
+	if svr.inShutdown {
+		return false
+	}
+	if svr.listeners == nil {
+		svr.listeners = make(map[net.Listener]struct{})
+	}
+	svr.listeners[lis] = struct{}{}
+	return true
+}
+
+func (svr *Server) untrackListener(lis net.Listener) {
+	svr.mu.Lock()
+	defer svr.mu.Unlock()
+	delete(svr.listeners, lis)
+}
+
+func (svr *Server) trackConn(c io.Closer, sc *serverConn) bool {
+	svr.mu.Lock()
+	defer svr.mu.Unlock()
+	if svr.inShutdown {
+		return false
+	}
+	if svr.conns == nil {
+		svr.conns = make(map[io.Closer]*serverConn)
+	}
+	svr.conns[c] = sc
+	return true
+}
+
+func (svr *Server) untrackConn(c io.Closer) {
+	svr.mu.Lock()
+	defer svr.mu.Unlock()
+	delete(svr.conns, c)
+}
+
+// 标记为关闭状态，关闭 listener，停止心跳并从 registry 注销
+// 返回当前所有的连接
+func (svr *Server) beginShutdown() map[io.Closer]*serverConn {
+	svr.mu.Lock()
+	svr.inShutdown = true
+	for lis := range svr.listeners {
+		_ = lis.Close()
+	}
+	conns := make(map[io.Closer]*serverConn, len(svr.conns))
+	for c, sc := range svr.conns {
+		conns[c] = sc
+	}
+	stopHeartbeat, registryAddr, serverAddr := svr.stopHeartbeat, svr.registryAddr, svr.serverAddr
+	svr.stopHeartbeat, svr.registryAddr = nil, ""
+	svr.mu.Unlock()
+
+	if stopHeartbeat != nil {
+		stopHeartbeat()
+	}
+	if registryAddr != "" {
+		if err := registry.Deregister(registryAddr, serverAddr); err != nil {
+			svr.logger().Warn("rpc server: deregister error", "registry", registryAddr, "err", err)
+		}
+	}
+	return conns
+}
+
+// 优雅关闭，ctx 超时后不再等待正在处理的请求，直接关闭连接并返回 ctx.Err()
+func (svr *Server) Shutdown(ctx context.Context) error {
+	conns := svr.beginShutdown()
+
+	done := make(chan struct{})
+	go func() {
+		for c, sc := range conns {
+			if sc == nil { // 还在握手阶段，没有正在处理的请求
+				_ = c.Close()
+				continue
+			}
+			sc.goAway()
+		}
+		for _, sc := range conns {
+			if sc != nil {
+				sc.wg.Wait()
+			}
+		}
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	for c := range conns {
+		_ = c.Close()
+	}
+	return err
+}
+
+// 立即关闭所有 listener 和连接
+func (svr *Server) Close() error {
+	conns := svr.beginShutdown()
+	for c := range conns {
+		_ = c.Close()
+	}
+	return nil
+}
+
+// 进入 draining 状态并通知客户端不要再发送新的请求
+func (sc *serverConn) goAway() {
+	sc.mu.Lock()
+	if sc.draining {
+		sc.mu.Unlock()
+		return
+	}
+	sc.draining = true
+	sc.mu.Unlock()
+
+	sc.sending.Lock()
+	defer sc.sending.Unlock()
+	if err := sc.cc.Write(&codec.Header{Type: codec.MsgGoAway}, invalidRequest); err != nil {
+		sc.logger.Warn("rpc server: write goaway error", "err", err)
+	}
+}
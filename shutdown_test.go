@@ -0,0 +1,102 @@
+package myrpc
+
+import (
+	"MyRPC/status"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestShutdown(t *testing.T) {
+	svr := &Server{}
+	n := &Named{name: "a", started: make(chan struct{}, 1), release: make(chan struct{})}
+	addr := startTestServer(t, svr, n)
+	client := dialTestClient(t, addr, nil)
+
+	waited := make(chan error, 1)
+	go func() { waited <- client.Call(context.Background(), "Named.Wait", 0, new(string)) }()
+	<-n.started
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- svr.Shutdown(context.Background()) }()
+	select {
+	case <-client.GoAway():
+	case <-time.After(2 * time.Second):
+		t.Fatal("GoAway was not delivered")
+	}
+
+	// 收到 GoAway 之后的新调用和新连接都被拒绝
+	if err := client.Call(context.Background(), "Named.Name", 0, new(string)); status.CodeOf(err) != status.Unavailable {
+		t.Fatalf("call after GoAway: err = %v, want Unavailable", err)
+	}
+	if c, err := Dial("tcp", addr, &Option{ConnectionTimeout: time.Second}); err == nil {
+		_ = c.Close()
+		t.Fatal("Dial succeeded after Shutdown")
+	}
+
+	// 正在处理的调用结束之前 Shutdown 不返回
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Shutdown returned %v with a call in flight", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(n.release)
+	if err := <-waited; err != nil {
+		t.Fatalf("in-flight call: %v", err)
+	}
+	if err := <-shutdown; err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-client.Disconnected():
+	case <-time.After(2 * time.Second):
+		t.Fatal("connection was not closed after Shutdown")
+	}
+}
+
+// ctx 结束时不再等待，直接关闭连接
+func TestShutdownTimeout(t *testing.T) {
+	svr := &Server{}
+	n := &Named{name: "a", started: make(chan struct{}, 1), release: make(chan struct{})}
+	defer close(n.release)
+	addr := startTestServer(t, svr, n)
+	client := dialTestClient(t, addr, nil)
+
+	waited := make(chan error, 1)
+	go func() { waited <- client.Call(context.Background(), "Named.Wait", 0, new(string)) }()
+	<-n.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := svr.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Shutdown = %v, want DeadlineExceeded", err)
+	}
+	if err := <-waited; err == nil {
+		t.Fatal("in-flight call succeeded after its connection was closed")
+	}
+}
+
+func TestClose(t *testing.T) {
+	svr := &Server{}
+	n := &Named{name: "a", started: make(chan struct{}, 1), release: make(chan struct{})}
+	defer close(n.release)
+	addr := startTestServer(t, svr, n)
+	client := dialTestClient(t, addr, nil)
+
+	waited := make(chan error, 1)
+	go func() { waited <- client.Call(context.Background(), "Named.Wait", 0, new(string)) }()
+	<-n.started
+
+	_ = svr.Close()
+	select {
+	case err := <-waited:
+		if err == nil {
+			t.Fatal("in-flight call succeeded after Close")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("in-flight call did not end after Close")
+	}
+	if err := client.Call(context.Background(), "Named.Name", 0, new(string)); status.CodeOf(err) != status.Unavailable {
+		t.Fatalf("call after Close: err = %v, want Unavailable", err)
+	}
+}
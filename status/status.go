@@ -0,0 +1,122 @@
+/*
+带错误码的 error，随 codec.Header 的 Code / Error / Details 字段在连接上传输
+
+服务端：服务方法可以返回 status.New / status.Errorf 构造的 error，客户端拿到相同的错误码
+        普通的 error 会被当作 Unknown 返回
+客户端：Client.Call 返回的 error 可以通过 errors.As 转为 *status.Error，或直接使用 status.CodeOf 取得错误码
+*/
+
+package status
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// 错误码，取值和含义与 gRPC 的 status code 保持一致
+type Code uint32
+
+const (
+	OK                 Code = 0
+	Canceled           Code = 1 // 调用方取消了请求
+	Unknown            Code = 2 // 服务方法返回了普通的 error
+	InvalidArgument    Code = 3 // 参数错误，如 body 解码失败
+	DeadlineExceeded   Code = 4 // 超时
+	NotFound           Code = 5 // 找不到服务或方法
+	AlreadyExists      Code = 6
+	PermissionDenied   Code = 7 // 调用方没有权限
+	ResourceExhausted  Code = 8
+	FailedPrecondition Code = 9
+	Aborted            Code = 10
+	OutOfRange         Code = 11
+	Unimplemented      Code = 12
+	Internal           Code = 13 // 框架内部错误，如服务方法 panic
+	Unavailable        Code = 14 // 服务暂时不可用，如连接断开、服务端正在关闭，可以重试
+	DataLoss           Code = 15
+	Unauthenticated    Code = 16 // 调用方身份认证失败
+)
+
+var codeNames = map[Code]string{
+	OK:                 "OK",
+	Canceled:           "Canceled",
+	Unknown:            "Unknown",
+	InvalidArgument:    "InvalidArgument",
+	DeadlineExceeded:   "DeadlineExceeded",
+	NotFound:           "NotFound",
+	AlreadyExists:      "AlreadyExists",
+	PermissionDenied:   "PermissionDenied",
+	ResourceExhausted:  "ResourceExhausted",
+	FailedPrecondition: "FailedPrecondition",
+	Aborted:            "Aborted",
+	OutOfRange:         "OutOfRange",
+	Unimplemented:      "Unimplemented",
+	Internal:           "Internal",
+	Unavailable:        "Unavailable",
+	DataLoss:           "DataLoss",
+	Unauthenticated:    "Unauthenticated",
+}
+
+func (c Code) String() string {
+	if name, ok := codeNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("Code(%d)", uint32(c))
+}
+
+type Error struct {
+	Code    Code
+	Message string
+	Details map[string]string // 可选的附加信息，如出错的字段名
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("rpc error: code = %s desc = %s", e.Code, e.Message)
+}
+
+func New(code Code, msg string) *Error {
+	return &Error{Code: code, Message: msg}
+}
+
+func Errorf(code Code, format string, a ...interface{}) *Error {
+	return New(code, fmt.Sprintf(format, a...))
+}
+
+// 返回附加了 kv 的副本，kv 以 key, value, key, value ... 的形式给出
+func (e *Error) WithDetails(kv ...string) *Error {
+	ret := &Error{Code: e.Code, Message: e.Message, Details: make(map[string]string, len(e.Details)+len(kv)/2)}
+	for k, v := range e.Details {
+		ret.Details[k] = v
+	}
+	for i := 0; i+1 < len(kv); i += 2 {
+		ret.Details[kv[i]] = kv[i+1]
+	}
+	return ret
+}
+
+// 将任意 error 转为 *Error：
+// 链上有 *Error 时直接返回；context 的错误转为对应的错误码；其余为 Unknown
+func Convert(err error) *Error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return New(DeadlineExceeded, err.Error())
+	case errors.Is(err, context.Canceled):
+		return New(Canceled, err.Error())
+	}
+	return New(Unknown, err.Error())
+}
+
+// 取得 err 的错误码，err 为 nil 时返回 OK
+func CodeOf(err error) Code {
+	if err == nil {
+		return OK
+	}
+	return Convert(err).Code
+}
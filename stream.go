@@ -0,0 +1,601 @@
+/*
+
+流式调用：在同一个连接上复用的一个逻辑调用，双方都可以发送多条消息
+
+服务方法签名：
+
+	func (T) M(ctx context.Context, args A, stream *myrpc.ServerStream) error
+
+args 为客户端发送的第一条消息，之后客户端发送的消息类型同样为 A，通过 stream.Recv 读取；
+服务方法通过 stream.Send 发送消息，返回时框架发送一个结束帧，返回的 error 随结束帧一起发送。
+  - 服务端流式：客户端只发送 args，服务方法多次 Send
+  - 客户端流式：客户端多次 Send，服务方法 Recv 到 io.EOF 后返回结果（Send 一次）
+  - 双向流式：双方交替收发
+
+| Header{Seq, MsgStreamOpen} | args |                    打开流，客户端 -> 服务端
+| Header{Seq, MsgStreamData} | msg |                      任意方向的一条消息
+| Header{Seq, MsgStreamHalfClose} | {} |                  客户端不再发送，服务端 Recv 返回 io.EOF
+| Header{Seq, MsgWindowUpdate, Window} | {} |             任意方向，归还发送额度
+| Header{Seq, MsgStreamEnd, Error} | {} |                  服务端 -> 客户端，Error 为空表示正常结束
+| Header{Seq, MsgCancel} | {} |                           客户端提前关闭流
+
+Client.Stream 以普通请求（MsgCall）的形式打开服务端流式调用，等价于打开后立即 half-close
+
+流控：
+每个流的每个方向各有一个以消息条数计的发送额度，初始值为 Option.StreamWindow。
+发送一条消息消耗一个额度，额度用完时 Send 阻塞，直到接收方通过 Recv 取走消息并发回 MsgWindowUpdate。
+等待额度时不持有连接的 sending 锁，接收方的读循环只负责把消息放入队列、从不阻塞，
+因此一个消费很慢的流不会影响同一连接上的其他调用。
+接收方不依赖对方遵守额度：队列中未取走的消息超过窗口时，以 ResourceExhausted 结束这个流。
+
+流式调用不受 HandleTimeout 限制，只受客户端 ctx 的截止时间和取消控制。
+同一个流的 Send 和 Recv 可以在两个协程中分别调用，但不要在多个协程中同时 Send 或同时 Recv。
+
+*/
+
+package myrpc
+
+import (
+	"MyRPC/codec"
+	"MyRPC/metadata"
+	"MyRPC/status"
+	"MyRPC/trace"
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"sync"
+)
+
+var typeOfServerStream = reflect.TypeOf((*ServerStream)(nil))
+
+const defaultStreamWindow = 64
+
+func (opt *Option) streamWindow() int {
+	if opt.StreamWindow > 0 {
+		return opt.StreamWindow
+	}
+	return defaultStreamWindow
+}
+
+// 接收端的消息队列：读循环解码后放入，调用方通过 recv 取出
+// 取出的条数累计到窗口的一半时通过 update 归还给发送方
+type recvBuffer struct {
+	mu       sync.Mutex
+	queue    []reflect.Value
+	notify   chan struct{}
+	done     bool
+	err      error
+	overflow error // 对方超出窗口发送时的错误
+	window   int
+	consumed int
+	update   func(n int)
+}
+
+func newRecvBuffer(window int) *recvBuffer {
+	return &recvBuffer{
+		notify: make(chan struct{}, 1),
+		window: window,
+	}
+}
+
+// 放入一条消息；队列已满说明对方没有遵守窗口，不再放入并返回 ResourceExhausted，由调用方结束这个流
+func (b *recvBuffer) push(v reflect.Value) error {
+	b.mu.Lock()
+	if b.done {
+		b.mu.Unlock()
+		return nil
+	}
+	if len(b.queue) >= b.window {
+		err := status.Errorf(status.ResourceExhausted, "stream: peer exceeded the flow control window of %d messages", b.window)
+		b.overflow = err
+		b.mu.Unlock()
+		return err
+	}
+	b.queue = append(b.queue, v)
+	b.mu.Unlock()
+	b.wakeup()
+	return nil
+}
+
+// 不再有新的消息，队列中剩余的消息仍然可以取出；返回是否是第一次调用
+func (b *recvBuffer) finish(err error) bool {
+	b.mu.Lock()
+	if b.done {
+		b.mu.Unlock()
+		return false
+	}
+	b.done, b.err = true, err
+	b.mu.Unlock()
+	b.wakeup()
+	return true
+}
+
+func (b *recvBuffer) finished() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.done
+}
+
+func (b *recvBuffer) overflowed() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.overflow
+}
+
+func (b *recvBuffer) wakeup() {
+	select {
+	case b.notify <- struct{}{}:
+	default:
+	}
+}
+
+// 取出下一条消息写入 v，队列为空且已经结束时返回结束的原因，正常结束为 io.EOF
+func (b *recvBuffer) recv(ctx context.Context, v interface{}) error {
+	for {
+		b.mu.Lock()
+		if len(b.queue) > 0 {
+			msg := b.queue[0]
+			b.queue = b.queue[1:]
+			var n int
+			if b.consumed++; b.consumed >= (b.window+1)/2 && !b.done {
+				n, b.consumed = b.consumed, 0
+			}
+			b.mu.Unlock()
+			if n > 0 && b.update != nil {
+				b.update(n)
+			}
+			reflect.ValueOf(v).Elem().Set(msg.Elem())
+			return nil
+		}
+		if b.done {
+			err := b.err
+			b.mu.Unlock()
+			if err == nil {
+				return io.EOF
+			}
+			return err
+		}
+		b.mu.Unlock()
+		select {
+		case <-b.notify:
+		case <-ctx.Done():
+			return status.Convert(ctx.Err())
+		}
+	}
+}
+
+// 发送端的额度
+type sendWindow struct {
+	mu      sync.Mutex
+	credits int
+	err     error // 非空表示流已经结束，不能再发送
+	notify  chan struct{}
+}
+
+func newSendWindow(window int) *sendWindow {
+	return &sendWindow{
+		credits: window,
+		notify:  make(chan struct{}, 1),
+	}
+}
+
+// 消耗一个额度，额度用完时阻塞等待
+func (w *sendWindow) acquire(ctx context.Context) error {
+	for {
+		w.mu.Lock()
+		if w.err != nil {
+			err := w.err
+			w.mu.Unlock()
+			return err
+		}
+		if w.credits > 0 {
+			w.credits--
+			w.mu.Unlock()
+			return nil
+		}
+		w.mu.Unlock()
+		select {
+		case <-w.notify:
+		case <-ctx.Done():
+			return status.Convert(ctx.Err())
+		}
+	}
+}
+
+func (w *sendWindow) add(n int) {
+	w.mu.Lock()
+	w.credits += n
+	w.mu.Unlock()
+	w.wakeup()
+}
+
+func (w *sendWindow) close(err error) {
+	w.mu.Lock()
+	if w.err == nil {
+		w.err = err
+	}
+	w.mu.Unlock()
+	w.wakeup()
+}
+
+func (w *sendWindow) wakeup() {
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+// 流中消息的类型：A 为指针时取其指向的类型
+func streamMsgType(argType reflect.Type) reflect.Type {
+	if argType.Kind() == reflect.Ptr {
+		return argType.Elem()
+	}
+	return argType
+}
+
+// 服务端的流，由框架创建并传给服务方法
+type ServerStream struct {
+	ctx           context.Context
+	sc            *serverConn
+	serviceMethod string
+	seq           uint64
+	msgType       reflect.Type
+	recv          *recvBuffer
+	send          *sendWindow
+}
+
+// 与服务方法的 ctx 相同，客户端取消或断开连接时被取消
+func (s *ServerStream) Context() context.Context {
+	return s.ctx
+}
+
+// 发送一条消息，额度用完时阻塞；客户端已经取消时返回 ctx 的错误
+func (s *ServerStream) Send(v interface{}) error {
+	if err := s.ctx.Err(); err != nil {
+		return status.Convert(err)
+	}
+	if err := s.send.acquire(s.ctx); err != nil {
+		return err
+	}
+	h := &codec.Header{
+		ServiceMethod: s.serviceMethod,
+		Seq:           s.seq,
+		Type:          codec.MsgStreamData,
+	}
+	s.sc.sending.Lock()
+	defer s.sc.sending.Unlock()
+	return s.sc.cc.Write(h, v)
+}
+
+// 读取客户端发送的下一条消息，v 为指向消息类型的指针；客户端 half-close 后返回 io.EOF
+func (s *ServerStream) Recv(v interface{}) error {
+	return s.recv.recv(s.ctx, v)
+}
+
+// 登记一个流，之后到达的数据帧和控制帧可以找到它
+// halfClosed 为 true 表示客户端以普通请求打开，不会再发送消息
+func (sc *serverConn) newStream(req *Request, halfClosed bool) *ServerStream {
+	window := sc.opt.streamWindow()
+	s := &ServerStream{
+		sc:            sc,
+		serviceMethod: req.H.ServiceMethod,
+		seq:           req.H.Seq,
+		msgType:       streamMsgType(req.Mtype.ArgType),
+		recv:          newRecvBuffer(window),
+		send:          newSendWindow(window),
+	}
+	s.recv.update = func(n int) {
+		sc.writeControl(&codec.Header{Seq: s.seq, Type: codec.MsgWindowUpdate, Window: uint32(n)})
+	}
+	if halfClosed {
+		s.recv.finish(nil)
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.streams[s.seq] = s
+	return s
+}
+
+func (sc *serverConn) getStream(seq uint64) *ServerStream {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.streams[seq]
+}
+
+func (sc *serverConn) removeStream(seq uint64) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	delete(sc.streams, seq)
+}
+
+// 发送一个没有 body 的控制帧
+func (sc *serverConn) writeControl(h *codec.Header) {
+	sc.sending.Lock()
+	defer sc.sending.Unlock()
+	_ = sc.cc.Write(h, invalidRequest)
+}
+
+// 读循环收到客户端的数据帧时调用，解码后放入对应流的队列
+func (sc *serverConn) readStreamData(h *codec.Header) error {
+	s := sc.getStream(h.Seq)
+	if s == nil { // 流已经结束
+		return sc.cc.ReadBody(nil)
+	}
+	v := reflect.New(s.msgType)
+	err := sc.cc.ReadBody(v.Interface())
+	if err == nil {
+		if err := s.recv.push(v); err != nil {
+			// 客户端没有遵守窗口，服务方法的 Send 和 Recv 都返回错误
+			s.recv.finish(err)
+			s.send.close(err)
+		}
+		return nil
+	}
+	if errors.Is(err, codec.ErrBadBody) {
+		// 流中丢了一条消息，服务方法的 Recv 返回错误
+		s.recv.finish(status.Errorf(status.InvalidArgument, "server: read stream message: %v", err))
+		return nil
+	}
+	return err
+}
+
+// 处理读循环收到的流控制帧
+func (sc *serverConn) handleStreamControl(h *codec.Header) {
+	s := sc.getStream(h.Seq)
+	if s == nil {
+		return
+	}
+	switch h.Type {
+	case codec.MsgStreamHalfClose:
+		s.recv.finish(nil)
+	case codec.MsgWindowUpdate:
+		s.send.add(int(h.Window))
+	}
+}
+
+// 处理流式调用，服务方法返回后发送结束帧
+// 同样经过拦截器链，拦截器拿到的 reply 为 nil
+func (svr *Server) handleStream(ctx context.Context, sc *serverConn, req *Request, stream *ServerStream) {
+	defer sc.wg.Done()
+	defer sc.removeInflight(req.H.Seq)
+	defer sc.removeStream(req.H.Seq)
+
+	var cancel context.CancelFunc
+	if req.H.Timeout > 0 {
+		ctx, cancel = context.WithTimeoutCause(ctx, req.H.Timeout, errClientDeadline)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+
+	ctx, span := startServerSpan(ctx, req.H)
+	ctx = metadata.NewIncomingContext(ctx, req.H.Metadata)
+	ctx = metadata.NewResponseContext(ctx)
+	info := &ServerInfo{
+		ServiceMethod: req.H.ServiceMethod,
+		Metadata:      req.H.Metadata,
+		RemoteAddr:    sc.remoteAddr,
+		Peer:          sc.peer,
+	}
+	stream.ctx = ctx
+	req.replyv = reflect.ValueOf(stream)
+
+	_, err := svr.invoke(ctx, req, info)
+	if err == nil {
+		// 超出窗口时即使服务方法正常返回，也以 ResourceExhausted 结束
+		err = stream.recv.overflowed()
+	}
+	span.Finish(err)
+	stream.send.close(io.EOF)
+	// 客户端已经取消、超时或断开，不需要结束帧
+	if ctx.Err() != nil {
+		return
+	}
+	req.H.Type = codec.MsgStreamEnd
+	req.H.Metadata = metadata.ResponseFromContext(ctx)
+	if err != nil {
+		setHeaderError(req.H, err)
+	}
+	svr.sendResponse(sc, req.H, invalidRequest)
+}
+
+// 客户端的流
+type ClientStream struct {
+	ctx     context.Context
+	client  *Client
+	call    *Call
+	msgType reflect.Type
+	recv    *recvBuffer
+	send    *sendWindow
+	span    *trace.Span // 打开时创建的 client span，流结束时结束
+
+	mu         sync.Mutex
+	stop       func() bool // 取消 ctx 上的 AfterFunc
+	sendClosed bool
+}
+
+// 发起服务端流式调用，发送 args 后不再发送消息
+// reply 与 Call 的 reply 一样为指针，这里只用于确定每条消息的类型
+// ctx 被取消时通知服务端结束这个流
+// 每个流创建一个 client span，traceparent 随打开流的请求头发送，流结束时以结束的原因结束 span
+func (client *Client) Stream(ctx context.Context, serviceMethod string, args, reply interface{}) (*ClientStream, error) {
+	return client.openStream(ctx, codec.MsgCall, serviceMethod, args, reply)
+}
+
+// 发起双向（或客户端）流式调用，args 为第一条消息，之后可以继续 Send，最后 CloseSend
+func (client *Client) NewStream(ctx context.Context, serviceMethod string, args, reply interface{}) (*ClientStream, error) {
+	return client.openStream(ctx, codec.MsgStreamOpen, serviceMethod, args, reply)
+}
+
+func (client *Client) openStream(ctx context.Context, typ codec.MsgType, serviceMethod string, args, reply interface{}) (*ClientStream, error) {
+	rt := reflect.TypeOf(reply)
+	if rt == nil || rt.Kind() != reflect.Ptr {
+		return nil, status.New(status.InvalidArgument, "rpc client: stream reply must be a pointer")
+	}
+	ctx, span := trace.Start(ctx, serviceMethod, trace.KindClient)
+	md, err := client.requestMetadata(ctx, serviceMethod, args)
+	if err != nil {
+		span.Finish(err)
+		return nil, err
+	}
+	deadline, _ := ctx.Deadline()
+	window := client.opt.streamWindow()
+	s := &ClientStream{
+		ctx:        ctx,
+		client:     client,
+		msgType:    rt.Elem(),
+		recv:       newRecvBuffer(window),
+		send:       newSendWindow(window),
+		span:       span,
+		sendClosed: typ == codec.MsgCall,
+	}
+	s.call = &Call{
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		Metadata:      md,
+		deadline:      deadline,
+		stream:        s,
+		typ:           typ,
+	}
+	client.send(s.call)
+	seq := s.call.Seq
+	s.recv.update = func(n int) {
+		client.writeControl(&codec.Header{Seq: seq, Type: codec.MsgWindowUpdate, Window: uint32(n)})
+	}
+	stop := context.AfterFunc(ctx, func() { _ = s.Close() })
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.recv.finished() { // 发送失败，已经结束
+		stop()
+	} else {
+		s.stop = stop
+	}
+	return s, nil
+}
+
+// 发送一条消息，额度用完时阻塞；流已经结束时返回 io.EOF 或结束的原因
+func (s *ClientStream) Send(v interface{}) error {
+	s.mu.Lock()
+	sendClosed := s.sendClosed
+	s.mu.Unlock()
+	if sendClosed {
+		return status.New(status.FailedPrecondition, "rpc client: send on closed stream")
+	}
+	if err := s.send.acquire(s.ctx); err != nil {
+		return err
+	}
+	h := &codec.Header{
+		ServiceMethod: s.call.ServiceMethod,
+		Seq:           s.call.Seq,
+		Type:          codec.MsgStreamData,
+	}
+	s.client.sending.Lock()
+	defer s.client.sending.Unlock()
+	return s.client.cc.Write(h, v)
+}
+
+// 通知服务端不再发送消息，服务端的 Recv 返回 io.EOF；之后仍然可以 Recv
+func (s *ClientStream) CloseSend() error {
+	s.mu.Lock()
+	if s.sendClosed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.sendClosed = true
+	s.mu.Unlock()
+	s.client.writeControl(&codec.Header{Seq: s.call.Seq, Type: codec.MsgStreamHalfClose})
+	return nil
+}
+
+// 取出下一条消息写入 reply，流正常结束时返回 io.EOF
+func (s *ClientStream) Recv(reply interface{}) error {
+	return s.recv.recv(context.Background(), reply)
+}
+
+// 服务端随结束帧返回的元数据，在 Recv 返回 io.EOF 之后可读
+func (s *ClientStream) ResponseMetadata() metadata.MD {
+	return s.call.ResponseMetadata
+}
+
+// 提前结束流，通知服务端取消服务方法的 ctx；之后 Recv 返回 Canceled
+func (s *ClientStream) Close() error {
+	if s.client.removeCall(s.call.Seq) != nil {
+		s.client.sendCancel(s.call.Seq)
+		err := s.ctx.Err()
+		if err == nil {
+			err = context.Canceled
+		}
+		s.call.Error = status.Errorf(status.Convert(err).Code, "rpc client: stream %s closed: %v", s.call.ServiceMethod, err)
+		s.call.done()
+	}
+	return nil
+}
+
+// 由 call.done 调用，结束帧、错误响应、连接断开都会走到这里
+func (s *ClientStream) finish(err error) {
+	if !s.recv.finish(err) {
+		return
+	}
+	s.span.Finish(err)
+	if err == nil {
+		s.send.close(io.EOF)
+	} else {
+		s.send.close(err)
+	}
+	s.mu.Lock()
+	stop := s.stop
+	s.mu.Unlock()
+	if stop != nil {
+		stop()
+	}
+}
+
+// 发送一个没有 body 的控制帧
+func (client *Client) writeControl(h *codec.Header) {
+	client.sending.Lock()
+	defer client.sending.Unlock()
+	if !client.IsAvailable() {
+		return
+	}
+	_ = client.cc.Write(h, struct{}{})
+}
+
+// receive 协程收到数据帧时调用，call 保留在 pending 中
+func (client *Client) receiveStreamData(h *codec.Header) error {
+	call := client.getCall(h.Seq)
+	if call == nil || call.stream == nil {
+		return client.cc.ReadBody(nil)
+	}
+	v := reflect.New(call.stream.msgType)
+	err := client.cc.ReadBody(v.Interface())
+	if err == nil {
+		if err := call.stream.recv.push(v); err != nil {
+			// 服务端没有遵守窗口，取消这个流
+			client.abortStream(call, err)
+		}
+		return nil
+	}
+	if errors.Is(err, codec.ErrBadBody) {
+		// 这一帧已经被跳过，但流中丢了一条消息，只能结束这个流
+		client.abortStream(call, status.Errorf(status.Internal, "reading body %v", err))
+		return nil
+	}
+	return err
+}
+
+// 在 receive 协程中结束一个流并通知服务端取消
+func (client *Client) abortStream(call *Call, err error) {
+	if client.removeCall(call.Seq) != nil {
+		go client.sendCancel(call.Seq)
+		call.Error = err
+		call.done()
+	}
+}
+
+// receive 协程收到服务端归还的额度
+func (client *Client) receiveWindowUpdate(h *codec.Header) {
+	if call := client.getCall(h.Seq); call != nil && call.stream != nil {
+		call.stream.send.add(int(h.Window))
+	}
+}
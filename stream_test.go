@@ -0,0 +1,259 @@
+package myrpc
+
+import (
+	"MyRPC/codec"
+	"MyRPC/status"
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+type StreamMsg struct {
+	N int
+}
+
+type Counter struct {
+	stopped chan error
+}
+
+// 发送 0..n-1
+func (c *Counter) Count(ctx context.Context, n int, stream *ServerStream) error {
+	for i := 0; i < n; i++ {
+		if err := stream.Send(StreamMsg{N: i}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 发送 n 条消息后返回错误
+func (c *Counter) Fail(ctx context.Context, n int, stream *ServerStream) error {
+	if err := c.Count(ctx, n, stream); err != nil {
+		return err
+	}
+	return status.New(status.DataLoss, "counter failed")
+}
+
+// 一直发送，直到客户端取消
+func (c *Counter) Forever(ctx context.Context, n int, stream *ServerStream) error {
+	for i := 0; ; i++ {
+		if err := stream.Send(StreamMsg{N: i}); err != nil {
+			c.stopped <- err
+			return err
+		}
+	}
+}
+
+// 读到结束，返回收到的全部消息和结束的原因
+func recvAll(s *ClientStream) ([]StreamMsg, error) {
+	var msgs []StreamMsg
+	for {
+		var msg StreamMsg
+		if err := s.Recv(&msg); err != nil {
+			return msgs, err
+		}
+		msgs = append(msgs, msg)
+	}
+}
+
+func TestServerStream(t *testing.T) {
+	addr := startTestServer(t, &Server{}, &Counter{})
+	for _, ct := range []codec.Type{codec.GobType, codec.JsonType, codec.GobFrameType} {
+		client := dialTestClient(t, addr, &Option{CodecType: ct})
+		s, err := client.Stream(context.Background(), "Counter.Count", 100, &StreamMsg{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs, err := recvAll(s)
+		if err != io.EOF {
+			t.Fatalf("%s: Recv = %v, want io.EOF", ct, err)
+		}
+		if len(msgs) != 100 {
+			t.Fatalf("%s: received %d messages, want 100", ct, len(msgs))
+		}
+		for i, msg := range msgs {
+			if msg.N != i {
+				t.Fatalf("%s: message %d = %d", ct, i, msg.N)
+			}
+		}
+	}
+}
+
+func TestServerStreamError(t *testing.T) {
+	addr := startTestServer(t, &Server{}, &Counter{})
+	client := dialTestClient(t, addr, nil)
+
+	s, _ := client.Stream(context.Background(), "Counter.Fail", 3, &StreamMsg{})
+	msgs, err := recvAll(s)
+	if len(msgs) != 3 || status.CodeOf(err) != status.DataLoss {
+		t.Fatalf("got %d messages and %v, want 3 and DataLoss", len(msgs), err)
+	}
+
+	s, _ = client.Stream(context.Background(), "Counter.Missing", 3, &StreamMsg{})
+	if _, err := recvAll(s); status.CodeOf(err) != status.NotFound {
+		t.Fatalf("Recv = %v, want NotFound", err)
+	}
+}
+
+func TestServerStreamCancel(t *testing.T) {
+	c := &Counter{stopped: make(chan error, 1)}
+	addr := startTestServer(t, &Server{}, c)
+	client := dialTestClient(t, addr, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s, _ := client.Stream(ctx, "Counter.Forever", 0, &StreamMsg{})
+	for i := 0; i < 5; i++ {
+		var msg StreamMsg
+		if err := s.Recv(&msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cancel()
+	select {
+	case <-c.stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler was not cancelled")
+	}
+	if _, err := recvAll(s); status.CodeOf(err) != status.Canceled {
+		t.Fatalf("Recv = %v, want Canceled", err)
+	}
+
+	// 同一个连接上的普通调用不受影响
+	s, _ = client.Stream(context.Background(), "Counter.Count", 2, &StreamMsg{})
+	if msgs, err := recvAll(s); err != io.EOF || len(msgs) != 2 {
+		t.Fatalf("got %d messages and %v", len(msgs), err)
+	}
+}
+
+type Echo struct {
+	release chan struct{}
+}
+
+// 把收到的每条消息原样发回
+func (e *Echo) Bidi(ctx context.Context, first StreamMsg, stream *ServerStream) error {
+	if err := stream.Send(first); err != nil {
+		return err
+	}
+	for {
+		var msg StreamMsg
+		err := stream.Recv(&msg)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(msg); err != nil {
+			return err
+		}
+	}
+}
+
+// 等到 release 关闭之后才开始 Recv，结束时发回收到的条数
+func (e *Echo) Hold(ctx context.Context, first StreamMsg, stream *ServerStream) error {
+	<-e.release
+	n := 0
+	for {
+		var msg StreamMsg
+		err := stream.Recv(&msg)
+		if err == io.EOF {
+			return stream.Send(StreamMsg{N: n})
+		}
+		if err != nil {
+			return err
+		}
+		n++
+	}
+}
+
+func TestBidiStream(t *testing.T) {
+	addr := startTestServer(t, &Server{}, &Echo{})
+	for _, ct := range []codec.Type{codec.GobType, codec.JsonType, codec.JsonFrameType} {
+		client := dialTestClient(t, addr, &Option{CodecType: ct, StreamWindow: 4})
+		s, err := client.NewStream(context.Background(), "Echo.Bidi", StreamMsg{N: 0}, &StreamMsg{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			for i := 1; i < 200; i++ {
+				if err := s.Send(StreamMsg{N: i}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+			_ = s.CloseSend()
+		}()
+		msgs, err := recvAll(s)
+		if err != io.EOF || len(msgs) != 200 {
+			t.Fatalf("%s: got %d messages and %v, want 200 and io.EOF", ct, len(msgs), err)
+		}
+		for i, msg := range msgs {
+			if msg.N != i {
+				t.Fatalf("%s: message %d = %d", ct, i, msg.N)
+			}
+		}
+	}
+}
+
+func TestStreamBackpressure(t *testing.T) {
+	const window = 4
+	e := &Echo{release: make(chan struct{})}
+	addr := startTestServer(t, &Server{}, e)
+	client := dialTestClient(t, addr, &Option{StreamWindow: window})
+
+	s, _ := client.NewStream(context.Background(), "Echo.Hold", StreamMsg{}, &StreamMsg{})
+	for i := 0; i < window; i++ {
+		if err := s.Send(StreamMsg{N: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 额度用完，服务端 Recv 之前 Send 阻塞
+	sent := make(chan error, 1)
+	go func() { sent <- s.Send(StreamMsg{N: window}) }()
+	select {
+	case err := <-sent:
+		t.Fatalf("Send beyond the window returned %v before the server consumed anything", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(e.release)
+	select {
+	case err := <-sent:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Send still blocked after the server consumed messages")
+	}
+	_ = s.CloseSend()
+	var msg StreamMsg
+	if err := s.Recv(&msg); err != nil || msg.N != window+1 {
+		t.Fatalf("server received %d messages (%v), want %d", msg.N, err, window+1)
+	}
+}
+
+// 不遵守窗口的客户端：绕过 Send 直接写数据帧
+func TestStreamWindowViolation(t *testing.T) {
+	const window = 4
+	e := &Echo{release: make(chan struct{})}
+	addr := startTestServer(t, &Server{}, e)
+	client := dialTestClient(t, addr, &Option{StreamWindow: window})
+
+	s, _ := client.NewStream(context.Background(), "Echo.Hold", StreamMsg{}, &StreamMsg{})
+	client.sending.Lock()
+	for i := 0; i < 3*window; i++ {
+		h := &codec.Header{ServiceMethod: "Echo.Hold", Seq: s.call.Seq, Type: codec.MsgStreamData}
+		if err := client.cc.Write(h, StreamMsg{N: i}); err != nil {
+			client.sending.Unlock()
+			t.Fatal(err)
+		}
+	}
+	client.sending.Unlock()
+	_ = s.CloseSend()
+	close(e.release)
+
+	if _, err := recvAll(s); status.CodeOf(err) != status.ResourceExhausted {
+		t.Fatalf("Recv = %v, want ResourceExhausted", err)
+	}
+}
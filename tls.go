@@ -0,0 +1,92 @@
+/*
+
+TLS 传输
+
+服务端：设置 Server.TLSConfig 后，Accept 收到的连接先完成 TLS 握手再读 Option；
+       HTTP CONNECT 方式由 http.Server 负责 TLS（ListenAndServeTLS），劫持出的连接本身就是 *tls.Conn
+客户端：设置 Option.TLSConfig 后，Dial / DialHTTP 使用 TLS 建立连接；XDial 支持 tls@host:port 和 https@host:port
+
+双向认证（mTLS）：服务端的 TLSConfig.ClientAuth 设置为 tls.RequireAndVerifyClientCert 并提供 ClientCAs，
+客户端在 TLSConfig.Certificates 中提供证书。验证通过的客户端证书通过 Peer 交给拦截器（ServerInfo.Peer）
+和服务方法（PeerFromContext）
+
+*/
+
+package myrpc
+
+import (
+	"MyRPC/metadata"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+)
+
+// 连接对端的信息
+type Peer struct {
+	Addr string               // 对端地址，连接不是 net.Conn 时为空
+	TLS  *tls.ConnectionState // 非 TLS 连接为 nil
+
+	// 经过验证的客户端证书，没有使用 mTLS 时为 nil
+	Certificate *x509.Certificate
+
+	// 客户端握手时随 Option 发送的认证元数据，见 auth.go
+	AuthMetadata metadata.MD
+}
+
+// 经过验证的客户端身份，取证书的 Subject.CommonName，没有证书时为空
+func (p *Peer) Identity() string {
+	if p == nil || p.Certificate == nil {
+		return ""
+	}
+	return p.Certificate.Subject.CommonName
+}
+
+type peerKey struct{}
+
+func newPeerContext(ctx context.Context, p *Peer) context.Context {
+	return context.WithValue(ctx, peerKey{}, p)
+}
+
+// 服务方法通过 ctx 拿到调用方的连接信息
+func PeerFromContext(ctx context.Context) (*Peer, bool) {
+	p, ok := ctx.Value(peerKey{}).(*Peer)
+	return p, ok
+}
+
+// 在握手完成（已经读过数据）之后调用
+func newPeer(conn io.ReadWriteCloser) *Peer {
+	p := &Peer{}
+	if nc, ok := conn.(net.Conn); ok {
+		p.Addr = nc.RemoteAddr().String()
+	}
+	if tc, ok := conn.(*tls.Conn); ok {
+		state := tc.ConnectionState()
+		p.TLS = &state
+		// VerifiedChains 只有在服务端验证了客户端证书时才非空
+		if len(state.VerifiedChains) > 0 && len(state.VerifiedChains[0]) > 0 {
+			p.Certificate = state.VerifiedChains[0][0]
+		}
+	}
+	return p
+}
+
+// 与 NewServer 相同，但使用 TLS 监听，注册到 registry 的地址为 tls@host:port
+func NewServerTLS(registryAddr string, config *tls.Config, svr chan *Server, opts ...ServerOption) {
+	newServer(registryAddr, config, svr, opts)
+}
+
+// XDial 的 tls@ / https@ 没有提供 TLSConfig 时使用默认配置（系统根证书，按地址校验服务端证书）
+func withTLS(opts []*Option) ([]*Option, error) {
+	opt, err := parseOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	if opt.TLSConfig != nil {
+		return []*Option{opt}, nil
+	}
+	o := *opt
+	o.TLSConfig = &tls.Config{}
+	return []*Option{&o}, nil
+}
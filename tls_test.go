@@ -0,0 +1,193 @@
+package myrpc
+
+import (
+	"MyRPC/registry"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type Whoami struct{}
+
+// 返回调用方的证书身份，非 TLS 连接返回 "plain"
+func (Whoami) Name(ctx context.Context, _ int, reply *string) error {
+	p, ok := PeerFromContext(ctx)
+	switch {
+	case !ok:
+		*reply = "<no peer>"
+	case p.TLS == nil:
+		*reply = "plain"
+	default:
+		*reply = p.Identity()
+	}
+	return nil
+}
+
+// 测试用的证书，CA 和由它签发的证书都在运行时生成
+type testPKI struct {
+	t      *testing.T
+	ca     *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+	caPool *x509.CertPool
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "myrpc test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	return &testPKI{t: t, ca: ca, caKey: key, caPool: pool}
+}
+
+// 签发一个同时可用于服务端和客户端的证书，SAN 为 127.0.0.1
+func (p *testPKI) issue(cn string) tls.Certificate {
+	p.t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, p.ca, &key.PublicKey, p.caKey)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func (p *testPKI) serverConfig(mutual bool) *tls.Config {
+	config := &tls.Config{Certificates: []tls.Certificate{p.issue("server")}}
+	if mutual {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		config.ClientCAs = p.caPool
+	}
+	return config
+}
+
+// cn 为空时不提供客户端证书
+func (p *testPKI) clientConfig(cn string) *tls.Config {
+	config := &tls.Config{RootCAs: p.caPool}
+	if cn != "" {
+		config.Certificates = []tls.Certificate{p.issue(cn)}
+	}
+	return config
+}
+
+func callWhoami(client *Client) (string, error) {
+	var name string
+	err := client.Call(context.Background(), "Whoami.Name", 0, &name)
+	return name, err
+}
+
+func TestTLS(t *testing.T) {
+	pki := newTestPKI(t)
+	addr := startTestServer(t, &Server{TLSConfig: pki.serverConfig(false)}, Whoami{})
+
+	client := dialTestClient(t, addr, &Option{TLSConfig: pki.clientConfig("")})
+	if name, err := callWhoami(client); err != nil || name != "" {
+		t.Fatalf("Whoami.Name = %q, %v; want an anonymous TLS peer", name, err)
+	}
+
+	client, err := XDial("tls@"+addr, &Option{TLSConfig: pki.clientConfig("")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, err := callWhoami(client); err != nil {
+		t.Fatal(err)
+	}
+
+	// 不信任服务端证书的 CA
+	if _, err := Dial("tcp", addr, &Option{TLSConfig: &tls.Config{}}); err == nil {
+		t.Fatal("Dial succeeded with an untrusted server certificate")
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	pki := newTestPKI(t)
+	addr := startTestServer(t, &Server{TLSConfig: pki.serverConfig(true)}, Whoami{})
+
+	client := dialTestClient(t, addr, &Option{TLSConfig: pki.clientConfig("alice")})
+	if name, err := callWhoami(client); err != nil || name != "alice" {
+		t.Fatalf("Whoami.Name = %q, %v; want alice", name, err)
+	}
+
+	// 没有客户端证书，或者证书不是受信任的 CA 签发的
+	other := newTestPKI(t)
+	for _, config := range []*tls.Config{pki.clientConfig(""), {RootCAs: pki.caPool, Certificates: []tls.Certificate{other.issue("mallory")}}} {
+		client, err := Dial("tcp", addr, &Option{TLSConfig: config, ConnectionTimeout: time.Second})
+		if err != nil {
+			continue
+		}
+		// TLS 1.3 的客户端在服务端验证证书之前就完成握手，错误在第一次调用时出现
+		if name, err := callWhoami(client); err == nil {
+			t.Fatalf("call without a trusted client certificate succeeded as %q", name)
+		}
+		_ = client.Close()
+	}
+}
+
+// TLS server 以 tls@ 地址注册，关闭时注销的也必须是这个地址
+func TestTLSServerDeregister(t *testing.T) {
+	reg := httptest.NewServer(registry.New(0))
+	defer reg.Close()
+	aliveServers := func() string {
+		rsp, err := http.Get(reg.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = rsp.Body.Close()
+		return rsp.Header.Get("X-rpc-servers")
+	}
+
+	pki := newTestPKI(t)
+	ch := make(chan *Server)
+	go NewServerTLS(reg.URL, pki.serverConfig(false), ch)
+	svr := <-ch
+	if got, want := aliveServers(), "tls@"+svr.Address; got != want {
+		t.Fatalf("registered servers = %q, want %q", got, want)
+	}
+	if err := svr.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := aliveServers(); got != "" {
+		t.Fatalf("registered servers after Shutdown = %q, want none", got)
+	}
+}
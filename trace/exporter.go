@@ -0,0 +1,51 @@
+package trace
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+// 把 span 以 JSON Lines 的格式逐行写出，方便离线查看和测试
+type JSONExporter struct {
+	mu  sync.Mutex
+	w   io.Writer
+	enc *json.Encoder
+}
+
+var _ Exporter = (*JSONExporter)(nil)
+
+func NewJSONExporter(w io.Writer) *JSONExporter {
+	return &JSONExporter{w: w, enc: json.NewEncoder(w)}
+}
+
+// 追加写入 path 对应的文件，不存在时创建
+func NewJSONFileExporter(path string) (*JSONExporter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return NewJSONExporter(f), nil
+}
+
+func (e *JSONExporter) ExportSpan(s *Span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := e.enc.Encode(s); err != nil {
+		slog.Error("trace: export span error", "err", err)
+	}
+}
+
+// 底层的 writer 实现了 io.Closer 时关闭它
+func (e *JSONExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if c, ok := e.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
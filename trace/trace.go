@@ -0,0 +1,251 @@
+/*
+分布式追踪：按 W3C Trace Context 规范在调用之间传播 traceparent / tracestate
+
+	traceparent: 00-<trace-id 32 位十六进制>-<parent-id 16 位十六进制>-<flags 2 位十六进制>
+
+客户端：Client.Call / Notify / Stream / NewStream 创建 client span，并通过 Inject 写入请求头的元数据；
+      XClient.Call / Notify / Broadcast 在外层再创建一个 internal span
+服务端：handleRequest 等通过 Extract 从请求头中取出上游的 SpanContext，以它为父节点创建 server span
+网关：从 HTTP 请求头中取出 traceparent，之后的调用都在同一条 trace 上
+
+结束的 span 交给通过 SetExporter 设置的 Exporter，没有设置时只传播不导出
+*/
+
+package trace
+
+import (
+	"MyRPC/metadata"
+	"MyRPC/status"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 元数据和 HTTP 请求头中使用的 key
+const (
+	TraceparentKey = "traceparent"
+	TracestateKey  = "tracestate"
+)
+
+type TraceID [16]byte
+type SpanID [8]byte
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+func (id SpanID) String() string  { return hex.EncodeToString(id[:]) }
+
+func (id TraceID) IsValid() bool { return id != TraceID{} }
+func (id SpanID) IsValid() bool  { return id != SpanID{} }
+
+const flagSampled = 0x01
+
+// 一个 span 在调用之间传播的部分
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Flags      byte
+	TraceState string
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+func (sc SpanContext) IsSampled() bool {
+	return sc.Flags&flagSampled != 0
+}
+
+// traceparent 头的值
+func (sc SpanContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceID, sc.SpanID, sc.Flags)
+}
+
+// 解析 traceparent；版本号为 ff、id 全为 0 或格式不对时返回 false
+func ParseTraceparent(s string) (SpanContext, bool) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, false
+	}
+	// 版本 00 只有 4 段，更高的版本允许在后面追加字段
+	if parts[0] == "00" && len(parts) != 4 {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, false
+	}
+	var flags [1]byte
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return sc, false
+	}
+	sc.Flags = flags[0]
+	return sc, sc.IsValid()
+}
+
+type SpanKind string
+
+const (
+	KindInternal SpanKind = "internal"
+	KindClient   SpanKind = "client"
+	KindServer   SpanKind = "server"
+)
+
+// 一次操作的记录，End 之后交给 Exporter
+type Span struct {
+	Name         string            `json:"name"`
+	Kind         SpanKind          `json:"kind"`
+	TraceID      string            `json:"trace_id"`
+	SpanID       string            `json:"span_id"`
+	ParentSpanID string            `json:"parent_span_id,omitempty"`
+	TraceState   string            `json:"trace_state,omitempty"`
+	Start        time.Time         `json:"start"`
+	End          time.Time         `json:"end"`
+	Attributes   map[string]string `json:"attributes,omitempty"`
+	Status       string            `json:"status"` // 状态码名称，见 status 包
+	Error        string            `json:"error,omitempty"`
+
+	mu    sync.Mutex
+	sc    SpanContext
+	ended bool
+}
+
+func (s *Span) SpanContext() SpanContext {
+	return s.sc
+}
+
+func (s *Span) SetAttribute(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	if s.Attributes == nil {
+		s.Attributes = make(map[string]string)
+	}
+	s.Attributes[key] = value
+}
+
+// 结束 span，err 非空时记录错误码；重复调用只有第一次生效
+func (s *Span) Finish(err error) {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.End = time.Now()
+	s.Status = status.CodeOf(err).String()
+	if err != nil {
+		s.Error = err.Error()
+	}
+	s.mu.Unlock()
+	if s.sc.IsSampled() {
+		if e := getExporter(); e != nil {
+			e.ExportSpan(s)
+		}
+	}
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+
+// 把 span 放进 ctx，之后在这个 ctx 上创建的 span 以它为父节点
+func ContextWithSpan(ctx context.Context, s *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, s)
+}
+
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// 把上游传来的 SpanContext 放进 ctx，作为之后创建的 span 的父节点
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// ctx 中当前的 SpanContext：优先取本地 span，其次是上游传来的
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	if s := SpanFromContext(ctx); s != nil {
+		return s.sc, true
+	}
+	sc, ok := ctx.Value(remoteKey{}).(SpanContext)
+	return sc, ok && sc.IsValid()
+}
+
+// 创建一个 span 并放进返回的 ctx；ctx 中没有父节点时开始一条新的 trace
+// 调用方负责调用 span.Finish
+func Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	s := &Span{
+		Name:  name,
+		Kind:  kind,
+		Start: time.Now(),
+	}
+	if parent, ok := SpanContextFromContext(ctx); ok {
+		s.sc = SpanContext{TraceID: parent.TraceID, Flags: parent.Flags, TraceState: parent.TraceState}
+		s.ParentSpanID = parent.SpanID.String()
+	} else {
+		_, _ = rand.Read(s.sc.TraceID[:])
+		s.sc.Flags = flagSampled
+	}
+	_, _ = rand.Read(s.sc.SpanID[:])
+	s.TraceID = s.sc.TraceID.String()
+	s.SpanID = s.sc.SpanID.String()
+	s.TraceState = s.sc.TraceState
+	return ContextWithSpan(ctx, s), s
+}
+
+// 返回 md 的副本，加上 ctx 中当前 SpanContext 对应的 traceparent / tracestate
+// ctx 中没有 span 时原样返回 md
+func Inject(ctx context.Context, md metadata.MD) metadata.MD {
+	sc, ok := SpanContextFromContext(ctx)
+	if !ok {
+		return md
+	}
+	md = md.Copy()
+	md.Set(TraceparentKey, sc.Traceparent())
+	if sc.TraceState != "" {
+		md.Set(TracestateKey, sc.TraceState)
+	}
+	return md
+}
+
+// 从元数据中取出上游的 SpanContext
+func Extract(md metadata.MD) (SpanContext, bool) {
+	sc, ok := ParseTraceparent(md.Get(TraceparentKey))
+	if !ok {
+		return sc, false
+	}
+	sc.TraceState = md.Get(TracestateKey)
+	return sc, true
+}
+
+// 导出结束的 span，需要支持并发调用
+type Exporter interface {
+	ExportSpan(s *Span)
+}
+
+var (
+	exporterMu sync.RWMutex
+	exporter   Exporter
+)
+
+// 设置全局的 Exporter，nil 表示不导出
+func SetExporter(e Exporter) {
+	exporterMu.Lock()
+	defer exporterMu.Unlock()
+	exporter = e
+}
+
+func getExporter() Exporter {
+	exporterMu.RLock()
+	defer exporterMu.RUnlock()
+	return exporter
+}
@@ -0,0 +1,122 @@
+package myrpc
+
+import (
+	"MyRPC/trace"
+	"bufio"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 把导出的 span 写入内存，测试结束时恢复为不导出
+func captureSpans(t *testing.T) *syncBuffer {
+	t.Helper()
+	var buf syncBuffer
+	trace.SetExporter(trace.NewJSONExporter(&buf))
+	t.Cleanup(func() { trace.SetExporter(nil) })
+	return &buf
+}
+
+// 解析 JSONExporter 的输出，只保留 traceID 上的 span
+func exportedSpans(t *testing.T, buf *syncBuffer, traceID string) []*trace.Span {
+	t.Helper()
+	var spans []*trace.Span
+	sc := bufio.NewScanner(strings.NewReader(buf.String()))
+	for sc.Scan() {
+		s := new(trace.Span)
+		if err := json.Unmarshal(sc.Bytes(), s); err != nil {
+			t.Fatalf("exporter output %q: %v", sc.Text(), err)
+		}
+		if s.TraceID == traceID {
+			spans = append(spans, s)
+		}
+	}
+	return spans
+}
+
+func findSpan(spans []*trace.Span, name string, kind trace.SpanKind) *trace.Span {
+	for _, s := range spans {
+		if s.Name == name && s.Kind == kind {
+			return s
+		}
+	}
+	return nil
+}
+
+func spanNames(spans []*trace.Span) []string {
+	names := make([]string, len(spans))
+	for i, s := range spans {
+		names[i] = s.Name + "/" + string(s.Kind)
+	}
+	return names
+}
+
+// 检查 method 有一个 parent 下的 client span，服务端的 server span 以它为父节点
+// server span 在响应写出之后才结束，需要等一会
+func waitClientServerSpans(t *testing.T, buf *syncBuffer, parent *trace.Span, method, wantStatus string) {
+	t.Helper()
+	var spans []*trace.Span
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		spans = exportedSpans(t, buf, parent.TraceID)
+		client, server := findSpan(spans, method, trace.KindClient), findSpan(spans, method, trace.KindServer)
+		if client == nil || server == nil {
+			continue
+		}
+		if client.ParentSpanID != parent.SpanID {
+			t.Fatalf("%s: client span parent = %s, want %s", method, client.ParentSpanID, parent.SpanID)
+		}
+		if server.ParentSpanID != client.SpanID {
+			t.Fatalf("%s: server span parent = %s, want the client span %s", method, server.ParentSpanID, client.SpanID)
+		}
+		if client.Status != wantStatus {
+			t.Fatalf("%s: client span status = %s, want %s", method, client.Status, wantStatus)
+		}
+		return
+	}
+	t.Fatalf("%s: client and server spans were not exported, got %v", method, spanNames(spans))
+}
+
+func TestTracePropagation(t *testing.T) {
+	buf := captureSpans(t)
+	a := &Audit{events: make(chan string, 10)}
+	addr := startTestServer(t, &Server{}, a, &Counter{})
+	client := dialTestClient(t, addr, nil)
+
+	ctx, root := trace.Start(context.Background(), "test", trace.KindInternal)
+
+	var reply int
+	if err := client.Call(ctx, "Audit.Record", "call", &reply); err != nil {
+		t.Fatal(err)
+	}
+	a.wait(t, "call")
+	waitClientServerSpans(t, buf, root, "Audit.Record", "OK")
+
+	// 单向调用在写出请求后结束 client span，服务端同样接在它下面
+	if err := client.Notify(ctx, "Audit.Reject", "notify"); err != nil {
+		t.Fatal(err)
+	}
+	waitClientServerSpans(t, buf, root, "Audit.Reject", "OK")
+
+	// 流的 client span 在流结束时结束
+	s, err := client.Stream(ctx, "Counter.Count", 3, new(StreamMsg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spans := exportedSpans(t, buf, root.TraceID); findSpan(spans, "Counter.Count", trace.KindClient) != nil {
+		t.Fatal("stream client span finished before the stream ended")
+	}
+	if msgs, err := recvAll(s); len(msgs) != 3 {
+		t.Fatalf("Recv = %v, %v", msgs, err)
+	}
+	waitClientServerSpans(t, buf, root, "Counter.Count", "OK")
+
+	s, err = client.NewStream(ctx, "Counter.Fail", 1, new(StreamMsg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = s.CloseSend()
+	_, _ = recvAll(s)
+	waitClientServerSpans(t, buf, root, "Counter.Fail", "DataLoss")
+}
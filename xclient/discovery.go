@@ -2,7 +2,7 @@ package xclient
 
 import (
 	"errors"
-	"log"
+	"log/slog"
 	"math"
 	"math/rand"
 	"net/http"
@@ -77,6 +77,9 @@ type DiscoveryCenter struct {
 	registryAddr string        // 表示注册中心
 	timeout      time.Duration // 服务列表过期时间
 	lastUpdate   time.Time     // 最后从注册中心更新服务列表的时间
+
+	Logger     *slog.Logger // nil 时使用 slog.Default()
+	HTTPClient *http.Client // 访问注册中心使用的客户端，nil 时使用 http.DefaultClient；registry 使用 https 时在这里配置 TLS
 }
 
 const defaultUpdateTimeout = time.Second * 10
@@ -101,19 +104,23 @@ func (d *DiscoveryCenter) Refresh() error {
 	if d.lastUpdate.Add(d.timeout).After(time.Now()) {
 		return nil
 	}
-	log.Println("discovery: refresh servers from registry:", d.registryAddr)
+	logger := d.logger()
+	logger.Debug("discovery: refresh servers from registry", "registry", d.registryAddr)
 
 	// 发送一个 Get 请求到注册中心
-	rsp, err := http.Get(d.registryAddr) /* 核心方法 */
+	httpClient := d.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	rsp, err := httpClient.Get(d.registryAddr) /* 核心方法 */
 	if err != nil {
-		log.Println("discovery: refresh: get from registry error", err)
+		logger.Warn("discovery: get from registry error", "registry", d.registryAddr, "err", err)
 		return err
 	}
 	defer rsp.Body.Close() // 确保关闭响应体
 
 	// 接收注册中心的响应
 	serverHeader := rsp.Header.Get("X-rpc-servers")
-	log.Printf("discovery: received server header: '%s'", serverHeader)
 
 	servers := strings.Split(serverHeader, ",")
 	d.servers = make([]string, 0)
@@ -125,11 +132,17 @@ func (d *DiscoveryCenter) Refresh() error {
 	}
 	d.lastUpdate = time.Now()
 
-	// 添加调试信息
-	log.Printf("discovery: updated servers list: %v", d.servers)
+	logger.Debug("discovery: updated servers list", "servers", d.servers)
 	return nil
 }
 
+func (d *DiscoveryCenter) logger() *slog.Logger {
+	if d.Logger != nil {
+		return d.Logger
+	}
+	return slog.Default()
+}
+
 // 注册中心对客户端提供的工具方法
 func (d *DiscoveryCenter) Get(mode SelectMode) (string, error) {
 	err := d.Refresh()
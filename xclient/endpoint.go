@@ -0,0 +1,155 @@
+/*
+
+XClient 的连接管理
+
+每个服务端地址对应一个 endpoint，缓存到这个地址的 Client：
+- 取用时检查 Client.IsAvailable，连接已经断开或收到 GoAway 时重新拨号，替换下来的 Client 在断开后关闭
+- 地址不再出现在 Discovery.GetAll 中时移除对应的 endpoint，检查在取用时进行，最多每 syncInterval 一次；Broadcast 每次都检查
+- 同一地址的拨号串行进行，不阻塞其他地址的调用
+
+Endpoints 返回每个地址的连接状态，状态取值与 myrpc.ReconnectingClient 相同
+
+*/
+
+package xclient
+
+import (
+	myrpc "MyRPC"
+	"MyRPC/status"
+	"sort"
+	"sync"
+	"time"
+)
+
+const syncInterval = time.Second
+
+type endpoint struct {
+	dialMu sync.Mutex // 串行化同一地址的拨号
+
+	// 以下字段由 XClient.mu 保护
+	client  *myrpc.Client
+	state   myrpc.ConnState
+	lastErr error
+	since   time.Time
+}
+
+func (ep *endpoint) setState(s myrpc.ConnState, err error) {
+	ep.state, ep.lastErr, ep.since = s, err, time.Now()
+}
+
+// 一个服务端地址的连接状态
+type EndpointState struct {
+	Addr      string
+	State     myrpc.ConnState // 连接断开后、下一次取用之前为 ConnIdle
+	LastError error           // 最近一次拨号失败的原因，State 为 ConnTransientFailure 时非 nil
+	Since     time.Time       // 进入当前状态的时间
+}
+
+func (xc *XClient) Endpoints() []EndpointState {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	ret := make([]EndpointState, 0, len(xc.clients))
+	for addr, ep := range xc.clients {
+		st := EndpointState{Addr: addr, State: ep.state, LastError: ep.lastErr, Since: ep.since}
+		if st.State == myrpc.ConnReady && !ep.client.IsAvailable() {
+			st.State = myrpc.ConnIdle
+		}
+		ret = append(ret, st)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Addr < ret[j].Addr })
+	return ret
+}
+
+// 返回 rpcAddr 对应的可用 Client，没有时拨号
+func (xc *XClient) dial(rpcAddr string) (*myrpc.Client, error) {
+	xc.maybeSync()
+
+	xc.mu.Lock()
+	ep := xc.clients[rpcAddr]
+	if ep == nil {
+		ep = &endpoint{state: myrpc.ConnIdle, since: time.Now()}
+		xc.clients[rpcAddr] = ep
+	}
+	if client := ep.client; client != nil && client.IsAvailable() {
+		xc.mu.Unlock()
+		return client, nil
+	}
+	xc.mu.Unlock()
+
+	ep.dialMu.Lock()
+	defer ep.dialMu.Unlock()
+	xc.mu.Lock()
+	// 等待 dialMu 期间可能已经有其他调用拨号成功
+	if client := ep.client; client != nil && client.IsAvailable() {
+		xc.mu.Unlock()
+		return client, nil
+	}
+	old := ep.client
+	ep.client = nil
+	ep.setState(myrpc.ConnConnecting, nil)
+	xc.mu.Unlock()
+
+	logger := xc.logger()
+	if old != nil {
+		logger.Info("xclient: connection broken, redialing", "rpc_addr", rpcAddr)
+		old.Retire()
+	} else {
+		logger.Debug("xclient: dialing new connection", "rpc_addr", rpcAddr)
+	}
+	client, err := myrpc.XDial(rpcAddr, xc.opt)
+
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	if err != nil {
+		ep.setState(myrpc.ConnTransientFailure, err)
+		logger.Warn("xclient: dial failed", "rpc_addr", rpcAddr, "err", err)
+		return nil, err
+	}
+	if xc.clients[rpcAddr] != ep { // 拨号期间被移除或 XClient 被关闭
+		_ = client.Close()
+		return nil, status.Errorf(status.Unavailable, "xclient: %s has been removed", rpcAddr)
+	}
+	ep.client = client
+	ep.setState(myrpc.ConnReady, nil)
+	logger.Debug("xclient: connected", "rpc_addr", rpcAddr)
+	return client, nil
+}
+
+func (xc *XClient) maybeSync() {
+	xc.mu.Lock()
+	due := time.Since(xc.synced) >= syncInterval
+	xc.mu.Unlock()
+	if !due {
+		return
+	}
+	servers, err := xc.d.GetAll()
+	if err != nil {
+		return
+	}
+	xc.evict(servers)
+}
+
+// 移除不在 servers 中的地址
+func (xc *XClient) evict(servers []string) {
+	alive := make(map[string]bool, len(servers))
+	for _, s := range servers {
+		alive[s] = true
+	}
+	var removed []string
+	xc.mu.Lock()
+	xc.synced = time.Now()
+	for addr, ep := range xc.clients {
+		if alive[addr] {
+			continue
+		}
+		delete(xc.clients, addr)
+		removed = append(removed, addr)
+		if ep.client != nil {
+			ep.client.Retire()
+		}
+	}
+	xc.mu.Unlock()
+	for _, addr := range removed {
+		xc.logger().Info("xclient: server removed from discovery, evicted", "rpc_addr", addr)
+	}
+}
@@ -0,0 +1,140 @@
+package xclient
+
+import (
+	myrpc "MyRPC"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 在 addr（XDial 格式）上启动只注册了 f 的 server，地址刚被释放时可能需要重试
+func startFlakyAt(t *testing.T, addr string, f *Flaky) *myrpc.Server {
+	t.Helper()
+	svr := &myrpc.Server{}
+	if err := svr.Register(f); err != nil {
+		t.Fatal(err)
+	}
+	var l net.Listener
+	var err error
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if l, err = net.Listen("tcp", strings.TrimPrefix(addr, "tcp@")); err == nil || time.Now().After(deadline) {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	go svr.Accept(l)
+	t.Cleanup(func() { _ = svr.Close() })
+	return svr
+}
+
+func endpointState(xc *XClient, addr string) (EndpointState, bool) {
+	for _, st := range xc.Endpoints() {
+		if st.Addr == addr {
+			return st, true
+		}
+	}
+	return EndpointState{}, false
+}
+
+func waitEndpointState(t *testing.T, xc *XClient, addr string, want myrpc.ConnState) EndpointState {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(5 * time.Millisecond) {
+		st, _ := endpointState(xc, addr)
+		if st.State == want {
+			return st
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s: state = %v, want %v", addr, st.State, want)
+		}
+	}
+}
+
+func TestEndpointRedial(t *testing.T) {
+	addr := deadAddr(t)
+	first, second := &Flaky{}, &Flaky{}
+	svr := startFlakyAt(t, addr, first)
+	xc := newTestXClient(t, nil, addr)
+
+	if _, ok := endpointState(xc, addr); ok {
+		t.Fatal("endpoint exists before the first call")
+	}
+	if err := callFlaky(xc, "Flaky.Get"); err != nil {
+		t.Fatal(err)
+	}
+	waitEndpointState(t, xc, addr, myrpc.ConnReady)
+
+	// 服务端退出后连接断开，下一次取用之前报告为 IDLE
+	_ = svr.Close()
+	waitEndpointState(t, xc, addr, myrpc.ConnIdle)
+
+	// 服务端没有恢复时拨号失败
+	if err := callFlaky(xc, "Flaky.Get"); err == nil {
+		t.Fatal("call succeeded with the server down")
+	}
+	if st := waitEndpointState(t, xc, addr, myrpc.ConnTransientFailure); st.LastError == nil {
+		t.Fatal("TRANSIENT_FAILURE without LastError")
+	}
+
+	// 服务端重启之后重新拨号
+	startFlakyAt(t, addr, second)
+	if err := callFlaky(xc, "Flaky.Get"); err != nil {
+		t.Fatal(err)
+	}
+	if first.count() != 1 || second.count() != 1 {
+		t.Fatalf("calls: first %d, second %d; want 1, 1", first.count(), second.count())
+	}
+	if st := waitEndpointState(t, xc, addr, myrpc.ConnReady); st.LastError != nil {
+		t.Fatalf("READY with LastError %v", st.LastError)
+	}
+}
+
+func TestEndpointEvict(t *testing.T) {
+	a, b := startFlaky(t, &Flaky{}), startFlaky(t, &Flaky{})
+	d := &orderedDiscovery{servers: []string{a, b}}
+	xc := NewXClient(d, RoundRobinSelect, nil)
+	t.Cleanup(func() { _ = xc.Close() })
+
+	var reply int
+	if err := xc.Broadcast(context.Background(), "Flaky.Get", 0, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if eps := xc.Endpoints(); len(eps) != 2 || eps[0].State != myrpc.ConnReady || eps[1].State != myrpc.ConnReady {
+		t.Fatalf("Endpoints = %+v, want two READY endpoints", eps)
+	}
+	xc.mu.Lock()
+	old := xc.clients[b].client
+	xc.mu.Unlock()
+
+	// b 不再出现在 Discovery 中，Broadcast 时移除
+	d.mu.Lock()
+	d.servers = []string{a}
+	d.mu.Unlock()
+	if err := xc.Broadcast(context.Background(), "Flaky.Get", 0, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if eps := xc.Endpoints(); len(eps) != 1 || eps[0].Addr != a {
+		t.Fatalf("Endpoints = %+v, want only %s", eps, a)
+	}
+	// 被移除的 Client 没有正在进行的调用，仍然可用，等连接断开或超时之后才关闭
+	if !old.IsAvailable() {
+		t.Fatal("evicted client was closed immediately")
+	}
+
+	// b 重新出现时新建连接
+	d.mu.Lock()
+	d.servers = []string{a, b}
+	d.mu.Unlock()
+	if err := xc.Broadcast(context.Background(), "Flaky.Get", 0, &reply); err != nil {
+		t.Fatal(err)
+	}
+	xc.mu.Lock()
+	redialed := xc.clients[b].client
+	xc.mu.Unlock()
+	if redialed == old {
+		t.Fatal("evicted client was reused")
+	}
+}
@@ -0,0 +1,227 @@
+/*
+
+XClient.Call 的重试
+
+通过 SetRetryPolicy 设置，没有设置时不重试。每次重试重新通过 Discovery 选择 server，尽量避开已经失败过的。
+
+一次失败是否重试：
+1. 请求没有发出去（拨号失败、连接已经断开或正在 GoAway）：总是可以重试
+2. 请求已经发出：只有方法被声明为幂等（IdempotentMethods）且错误码在 RetryableCodes 中时才重试，
+   非幂等的方法可能已经在服务端执行过，不会重试
+另外还受 MaxAttempts、调用方 ctx 和 RetryBudget 的限制。
+
+RetryBudget 是所有调用共享的令牌桶，用于避免服务端整体故障时重试把负载放大：
+每次失败（包括不会重试的失败）消耗一个令牌，每次成功加回 tokenRatio 个，令牌数不超过 maxTokens 的一半时不再重试（见 NewRetryBudget）。
+
+*/
+
+package xclient
+
+import (
+	myrpc "MyRPC"
+	"MyRPC/status"
+	"MyRPC/trace"
+	"context"
+	"errors"
+	"math"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type RetryPolicy struct {
+	MaxAttempts    int           // 包括第一次调用在内的最多尝试次数，不大于 1 时不重试
+	RetryableCodes []status.Code // 已发出的幂等请求遇到这些错误码时重试，为空时只重试 Unavailable
+
+	BaseDelay  time.Duration // 第一次重试前的等待时间，默认 100ms
+	MaxDelay   time.Duration // 等待时间的上限，默认 5s
+	Multiplier float64       // 每次重试等待时间的倍数，默认 2
+	Jitter     float64       // 等待时间在 [1-Jitter, 1+Jitter] 倍之间随机，默认 0.2
+
+	PerAttemptTimeout time.Duration // 每次尝试的超时时间，0 表示只受调用方 ctx 限制
+
+	// 幂等的方法，支持 path.Match 通配符，如 "Foo.Get*"、"Cache.*"
+	IdempotentMethods []string
+
+	Budget *RetryBudget // 为 nil 时不限制
+}
+
+// 设置 Call 的重试策略，p 为 nil 时不重试
+func (xc *XClient) SetRetryPolicy(p *RetryPolicy) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.retry = p
+}
+
+func (p *RetryPolicy) idempotent(serviceMethod string) bool {
+	for _, pattern := range p.IdempotentMethods {
+		if ok, _ := path.Match(pattern, serviceMethod); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *RetryPolicy) retryableCode(code status.Code) bool {
+	if len(p.RetryableCodes) == 0 {
+		return code == status.Unavailable
+	}
+	for _, c := range p.RetryableCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// 第 retry 次（从 0 开始）重试之前的等待时间
+func (p *RetryPolicy) backoff(retry int) time.Duration {
+	base, maxDelay, mult, jitter := p.BaseDelay, p.MaxDelay, p.Multiplier, p.Jitter
+	if base <= 0 {
+		base = 100 * time.Millisecond
+	}
+	if maxDelay <= 0 {
+		maxDelay = 5 * time.Second
+	}
+	if mult < 1 {
+		mult = 2
+	}
+	if jitter <= 0 || jitter > 1 {
+		jitter = 0.2
+	}
+	return myrpc.Backoff(retry, base, maxDelay, mult, jitter)
+}
+
+// 重试令牌桶，可以在多个 XClient 之间共享
+type RetryBudget struct {
+	maxTokens  float64
+	tokenRatio float64
+
+	mu     sync.Mutex
+	tokens float64
+}
+
+// maxTokens 默认 10，tokenRatio 默认 0.1
+func NewRetryBudget(maxTokens, tokenRatio float64) *RetryBudget {
+	if maxTokens <= 0 {
+		maxTokens = 10
+	}
+	if tokenRatio <= 0 {
+		tokenRatio = 0.1
+	}
+	return &RetryBudget{maxTokens: maxTokens, tokenRatio: tokenRatio, tokens: maxTokens}
+}
+
+func (b *RetryBudget) onSuccess() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = math.Min(b.tokens+b.tokenRatio, b.maxTokens)
+}
+
+// 记录一次失败，返回是否还允许重试
+func (b *RetryBudget) onFailure() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = math.Max(b.tokens-1, 0)
+	return b.tokens > b.maxTokens/2
+}
+
+// 请求没有发出时 attempt 返回的错误，这类失败总是可以重试
+type notSentError struct{ err error }
+
+func (e *notSentError) Error() string { return e.err.Error() }
+func (e *notSentError) Unwrap() error { return e.err }
+
+// 拦截器链的末端：按负载均衡选择 server，失败时按 RetryPolicy 重试
+func (xc *XClient) call(ctx context.Context, serviceMethod string, args, reply interface{}, opts ...myrpc.CallOption) error {
+	xc.mu.Lock()
+	p := xc.retry
+	xc.mu.Unlock()
+
+	tried := make(map[string]bool)
+	for attempt := 0; ; attempt++ {
+		rpcAddr, err := xc.pick(tried)
+		if err != nil {
+			return err
+		}
+		tried[rpcAddr] = true
+		if span := trace.SpanFromContext(ctx); span != nil {
+			span.SetAttribute("rpc.server", rpcAddr)
+			span.SetAttribute("rpc.attempts", strconv.Itoa(attempt+1))
+		}
+		err = xc.attempt(ctx, p, rpcAddr, serviceMethod, args, reply, opts...)
+		if p == nil {
+			return unwrapNotSent(err)
+		}
+		if err == nil {
+			if p.Budget != nil {
+				p.Budget.onSuccess()
+			}
+			return nil
+		}
+		// 每次失败都计入预算，预算只决定还能不能重试
+		withinBudget := p.Budget == nil || p.Budget.onFailure()
+		if !withinBudget || !xc.shouldRetry(ctx, p, attempt, serviceMethod, err) {
+			return unwrapNotSent(err)
+		}
+		delay := p.backoff(attempt)
+		xc.logger().Debug("xclient: retrying call", "service_method", serviceMethod, "rpc_addr", rpcAddr,
+			"attempt", attempt+1, "delay", delay, "err", err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return unwrapNotSent(err)
+		}
+	}
+}
+
+// 每次尝试使用独立的超时
+func (xc *XClient) attempt(ctx context.Context, p *RetryPolicy, rpcAddr, serviceMethod string, args, reply interface{}, opts ...myrpc.CallOption) error {
+	if p != nil && p.PerAttemptTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.PerAttemptTimeout)
+		defer cancel()
+	}
+	client, err := xc.dial(rpcAddr)
+	if err != nil {
+		return &notSentError{err}
+	}
+	err = client.Call(ctx, serviceMethod, args, reply, opts...)
+	if errors.Is(err, myrpc.ErrShutDown) { // 连接已经断开或正在 GoAway，请求没有登记
+		return &notSentError{err}
+	}
+	return err
+}
+
+func (xc *XClient) shouldRetry(ctx context.Context, p *RetryPolicy, attempt int, serviceMethod string, err error) bool {
+	if attempt+1 >= p.MaxAttempts || ctx.Err() != nil {
+		return false
+	}
+	var ns *notSentError
+	return errors.As(err, &ns) || p.idempotent(serviceMethod) && p.retryableCode(status.CodeOf(err))
+}
+
+// 优先选择还没有尝试过的 server；Discovery 只有这些 server 时允许重复
+func (xc *XClient) pick(tried map[string]bool) (string, error) {
+	var rpcAddr string
+	for i := 0; i <= len(tried); i++ {
+		addr, err := xc.d.Get(xc.mode)
+		if err != nil {
+			return "", err
+		}
+		rpcAddr = addr
+		if !tried[addr] {
+			break
+		}
+	}
+	return rpcAddr, nil
+}
+
+func unwrapNotSent(err error) error {
+	var ns *notSentError
+	if errors.As(err, &ns) {
+		return ns.err
+	}
+	return err
+}
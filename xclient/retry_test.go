@@ -0,0 +1,257 @@
+package xclient
+
+import (
+	myrpc "MyRPC"
+	"MyRPC/status"
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 按固定顺序循环返回 servers，让每次尝试落到哪个 server 是确定的
+type orderedDiscovery struct {
+	mu      sync.Mutex
+	servers []string
+	next    int
+}
+
+func (d *orderedDiscovery) Refresh() error { return nil }
+
+func (d *orderedDiscovery) Get(SelectMode) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s := d.servers[d.next%len(d.servers)]
+	d.next++
+	return s, nil
+}
+
+func (d *orderedDiscovery) GetAll() ([]string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.servers...), nil
+}
+
+// err 不为 nil 时每个方法都返回 err，delay 是 Slow 的处理时间
+type Flaky struct {
+	calls int32
+	delay time.Duration
+
+	mu  sync.Mutex
+	err error
+}
+
+func (f *Flaky) setErr(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.err = err
+}
+
+func (f *Flaky) result() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.err
+}
+
+func (f *Flaky) Get(ctx context.Context, _ int, reply *int) error {
+	atomic.AddInt32(&f.calls, 1)
+	*reply = 42
+	return f.result()
+}
+
+func (f *Flaky) Put(ctx context.Context, _ int, reply *int) error {
+	atomic.AddInt32(&f.calls, 1)
+	*reply = 42
+	return f.result()
+}
+
+func (f *Flaky) Slow(ctx context.Context, _ int, reply *int) error {
+	atomic.AddInt32(&f.calls, 1)
+	select {
+	case <-time.After(f.delay):
+	case <-ctx.Done():
+	}
+	*reply = 42
+	return f.result()
+}
+
+func (f *Flaky) count() int {
+	return int(atomic.LoadInt32(&f.calls))
+}
+
+// 启动一个只注册了 f 的 server，返回 XDial 格式的地址
+func startFlaky(t *testing.T, f *Flaky) string {
+	t.Helper()
+	svr := &myrpc.Server{}
+	if err := svr.Register(f); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go svr.Accept(l)
+	t.Cleanup(func() { _ = svr.Close() })
+	return "tcp@" + l.Addr().String()
+}
+
+// 一个没有监听的地址，拨号失败，请求不会发出
+func deadAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	_ = l.Close()
+	return "tcp@" + addr
+}
+
+func newTestXClient(t *testing.T, p *RetryPolicy, servers ...string) *XClient {
+	t.Helper()
+	xc := NewXClient(&orderedDiscovery{servers: servers}, RoundRobinSelect, nil)
+	xc.SetRetryPolicy(p)
+	t.Cleanup(func() { _ = xc.Close() })
+	return xc
+}
+
+func callFlaky(xc *XClient, method string) error {
+	var reply int
+	return xc.Call(context.Background(), method, 0, &reply)
+}
+
+func TestRetryIdempotent(t *testing.T) {
+	bad := &Flaky{err: status.New(status.Unavailable, "overloaded")}
+	good := &Flaky{}
+	p := &RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, IdempotentMethods: []string{"Flaky.Get"}}
+	servers := []string{startFlaky(t, bad), startFlaky(t, good)}
+	xc := newTestXClient(t, p, servers...)
+
+	// 幂等的方法在另一个 server 上重试
+	if err := callFlaky(xc, "Flaky.Get"); err != nil {
+		t.Fatal(err)
+	}
+	if bad.count() != 1 || good.count() != 1 {
+		t.Fatalf("calls: bad %d, good %d; want 1, 1", bad.count(), good.count())
+	}
+
+	// 请求已经发出的非幂等方法不重试
+	if err := callFlaky(xc, "Flaky.Put"); status.CodeOf(err) != status.Unavailable {
+		t.Fatalf("Put: err = %v, want Unavailable", err)
+	}
+	if bad.count() != 2 || good.count() != 1 {
+		t.Fatalf("calls: bad %d, good %d; want 2, 1", bad.count(), good.count())
+	}
+
+	// 不在 RetryableCodes 中的错误码不重试
+	bad.setErr(status.New(status.InvalidArgument, "bad request"))
+	xc = newTestXClient(t, p, servers...)
+	if err := callFlaky(xc, "Flaky.Get"); status.CodeOf(err) != status.InvalidArgument {
+		t.Fatalf("Get: err = %v, want InvalidArgument", err)
+	}
+	if bad.count() != 3 || good.count() != 1 {
+		t.Fatalf("calls: bad %d, good %d; want 3, 1", bad.count(), good.count())
+	}
+}
+
+// 拨号失败时请求没有发出，非幂等的方法也可以重试
+func TestRetryNotSent(t *testing.T) {
+	good := &Flaky{}
+	dead, addr := deadAddr(t), startFlaky(t, good)
+
+	xc := newTestXClient(t, nil, dead, addr)
+	if err := callFlaky(xc, "Flaky.Put"); status.CodeOf(err) == status.OK {
+		t.Fatal("call to a dead address succeeded without a retry policy")
+	}
+
+	xc = newTestXClient(t, &RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond}, dead, addr)
+	if err := callFlaky(xc, "Flaky.Put"); err != nil {
+		t.Fatal(err)
+	}
+	if good.count() != 1 {
+		t.Fatalf("calls = %d, want 1", good.count())
+	}
+}
+
+func TestRetryPerAttemptTimeout(t *testing.T) {
+	slow := &Flaky{delay: time.Second}
+	fast := &Flaky{}
+	xc := newTestXClient(t, &RetryPolicy{
+		MaxAttempts:       2,
+		BaseDelay:         time.Millisecond,
+		PerAttemptTimeout: 50 * time.Millisecond,
+		RetryableCodes:    []status.Code{status.DeadlineExceeded},
+		IdempotentMethods: []string{"Flaky.*"},
+	}, startFlaky(t, slow), startFlaky(t, fast))
+
+	start := time.Now()
+	if err := callFlaky(xc, "Flaky.Slow"); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("call took %v, the first attempt was not cut short", elapsed)
+	}
+	if slow.count() != 1 || fast.count() != 1 {
+		t.Fatalf("calls: slow %d, fast %d; want 1, 1", slow.count(), fast.count())
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	bad := &Flaky{err: status.New(status.Unavailable, "overloaded")}
+	// 令牌数大于 2 时才允许重试：4 -> 3 重试，3 -> 2 停止
+	budget := NewRetryBudget(4, 1)
+	xc := newTestXClient(t, &RetryPolicy{MaxAttempts: 10, BaseDelay: time.Millisecond, IdempotentMethods: []string{"Flaky.*"}, Budget: budget},
+		startFlaky(t, bad))
+
+	if err := callFlaky(xc, "Flaky.Get"); status.CodeOf(err) != status.Unavailable {
+		t.Fatalf("err = %v, want Unavailable", err)
+	}
+	if bad.count() != 2 {
+		t.Fatalf("calls = %d, want 2", bad.count())
+	}
+	// 预算用完之后不再重试
+	_ = callFlaky(xc, "Flaky.Get")
+	if bad.count() != 3 {
+		t.Fatalf("calls = %d, want 3", bad.count())
+	}
+
+	// 成功的调用加回令牌：1 -> 4，之后又可以重试一次
+	bad.setErr(nil)
+	for i := 0; i < 3; i++ {
+		if err := callFlaky(xc, "Flaky.Get"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bad.setErr(status.New(status.Unavailable, "overloaded"))
+	_ = callFlaky(xc, "Flaky.Get")
+	if bad.count() != 8 {
+		t.Fatalf("calls = %d, want 8", bad.count())
+	}
+}
+
+// 不重试的失败同样消耗预算：非幂等方法的失败用完预算之后，幂等方法也不再重试
+func TestRetryBudgetNonIdempotent(t *testing.T) {
+	bad := &Flaky{err: status.New(status.Unavailable, "overloaded")}
+	budget := NewRetryBudget(4, 1)
+	xc := newTestXClient(t, &RetryPolicy{MaxAttempts: 10, BaseDelay: time.Millisecond, IdempotentMethods: []string{"Flaky.Get"}, Budget: budget},
+		startFlaky(t, bad))
+
+	// 4 -> 3 -> 2，Put 不重试
+	for i := 0; i < 2; i++ {
+		if err := callFlaky(xc, "Flaky.Put"); status.CodeOf(err) != status.Unavailable {
+			t.Fatalf("Put: err = %v, want Unavailable", err)
+		}
+	}
+	if bad.count() != 2 {
+		t.Fatalf("calls = %d, want 2", bad.count())
+	}
+	// 2 -> 1，预算不足，Get 也不重试
+	if err := callFlaky(xc, "Flaky.Get"); status.CodeOf(err) != status.Unavailable {
+		t.Fatalf("Get: err = %v, want Unavailable", err)
+	}
+	if bad.count() != 3 {
+		t.Fatalf("calls = %d, want 3; Get was retried after the budget ran out", bad.count())
+	}
+}
@@ -0,0 +1,76 @@
+package xclient
+
+import (
+	"MyRPC/trace"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+type spanBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *spanBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// 按 name/kind 索引导出的 span
+func (b *spanBuffer) spans(t *testing.T) map[string]*trace.Span {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	spans := make(map[string]*trace.Span)
+	sc := bufio.NewScanner(bytes.NewReader(b.buf.Bytes()))
+	for sc.Scan() {
+		s := new(trace.Span)
+		if err := json.Unmarshal(sc.Bytes(), s); err != nil {
+			t.Fatalf("exporter output %q: %v", sc.Text(), err)
+		}
+		spans[s.Name+"/"+string(s.Kind)] = s
+	}
+	return spans
+}
+
+// xclient.Notify -> Client.Notify -> 服务端，三个 span 在同一条 trace 上依次嵌套
+func TestNotifyTrace(t *testing.T) {
+	var buf spanBuffer
+	trace.SetExporter(trace.NewJSONExporter(&buf))
+	t.Cleanup(func() { trace.SetExporter(nil) })
+
+	f := &Flaky{}
+	xc := newTestXClient(t, nil, startFlaky(t, f))
+	if err := xc.Notify(context.Background(), "Flaky.Put", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	var spans map[string]*trace.Span
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		spans = buf.spans(t)
+		if spans["Flaky.Put/server"] != nil || time.Now().After(deadline) {
+			break
+		}
+	}
+	outer, client, server := spans["xclient.Notify Flaky.Put/internal"], spans["Flaky.Put/client"], spans["Flaky.Put/server"]
+	if outer == nil || client == nil || server == nil {
+		names := make([]string, 0, len(spans))
+		for name := range spans {
+			names = append(names, name)
+		}
+		t.Fatalf("exported spans = %v, want xclient, client and server spans", names)
+	}
+	if client.TraceID != outer.TraceID || server.TraceID != outer.TraceID {
+		t.Fatalf("trace ids: xclient %s, client %s, server %s", outer.TraceID, client.TraceID, server.TraceID)
+	}
+	if client.ParentSpanID != outer.SpanID || server.ParentSpanID != client.SpanID {
+		t.Fatalf("client parent = %s, want %s; server parent = %s, want %s",
+			client.ParentSpanID, outer.SpanID, server.ParentSpanID, client.SpanID)
+	}
+}
@@ -2,11 +2,14 @@ package xclient
 
 import (
 	myrpc "MyRPC"
+	"MyRPC/trace"
 	"context"
 	"io"
-	"log"
+	"log/slog"
 	"reflect"
+	"strings"
 	"sync"
+	"time"
 )
 
 type XClient struct {
@@ -14,7 +17,11 @@ type XClient struct {
 	mode    SelectMode
 	opt     *myrpc.Option
 	mu      sync.Mutex
-	clients map[string]*myrpc.Client
+	clients map[string]*endpoint // 服务端地址到连接的映射，见 endpoint.go
+	synced  time.Time            // 上一次按 Discovery.GetAll 清理 clients 的时间
+
+	interceptors []myrpc.ClientInterceptor
+	retry        *RetryPolicy // 见 retry.go
 }
 
 var _ io.Closer = (*XClient)(nil)
@@ -24,65 +31,103 @@ func NewXClient(d Discovery, mode SelectMode, opt *myrpc.Option) *XClient {
 		d:       d,
 		mode:    mode,
 		opt:     opt,
-		clients: make(map[string]*myrpc.Client),
+		clients: make(map[string]*endpoint),
 	}
 }
 
 func (xc *XClient) Close() error {
 	xc.mu.Lock()
 	defer xc.mu.Unlock()
-	for key, client := range xc.clients {
-		_ = client.Close()
+	for key, ep := range xc.clients {
+		if ep.client != nil {
+			_ = ep.client.Close()
+		}
 		delete(xc.clients, key)
 	}
 	return nil
 }
 
-func (xc *XClient) dial(rpcAddr string) (*myrpc.Client, error) {
+// 与 Client 共用 Option.Logger
+func (xc *XClient) logger() *slog.Logger {
+	if xc.opt != nil && xc.opt.Logger != nil {
+		return xc.opt.Logger
+	}
+	return slog.Default()
+}
+
+// 注册拦截器，包裹 Call、Notify 和 Broadcast，按注册顺序执行
+// Broadcast 经过拦截器链一次，而不是每个 server 一次
+func (xc *XClient) Use(interceptors ...myrpc.ClientInterceptor) {
 	xc.mu.Lock()
 	defer xc.mu.Unlock()
-	client := xc.clients[rpcAddr] // map 中添加服务端地址 rpcAddr 和 client 的映射，缓存起来
-	if client == nil {
-		log.Printf("xclient: dialing new connection to %s", rpcAddr)
-		var err error
-		client, err = myrpc.XDial(rpcAddr, xc.opt)
-		if err != nil {
-			log.Printf("xclient: failed to dial %s: %v", rpcAddr, err)
-			return nil, err
-		}
-		log.Printf("xclient: successfully connected to %s", rpcAddr)
-		xc.clients[rpcAddr] = client
-	}
-	return client, nil
+	xc.interceptors = append(xc.interceptors, interceptors...)
+}
+
+func (xc *XClient) chain(invoker myrpc.Invoker) myrpc.Invoker {
+	xc.mu.Lock()
+	interceptors := xc.interceptors
+	xc.mu.Unlock()
+	return myrpc.ChainClientInterceptors(interceptors, invoker)
 }
 
-func (xc *XClient) callWithAddr(rpcAddr string, ctx context.Context, serviceMethod string, args, reply interface{}) error {
+func (xc *XClient) callWithAddr(rpcAddr string, ctx context.Context, serviceMethod string, args, reply interface{}, opts ...myrpc.CallOption) error {
 	client, err := xc.dial(rpcAddr) // 从 xc 的缓存中拿到 addr 对应的 client 实例
 	if err != nil {
 		return err
 	}
-	return client.Call(ctx, serviceMethod, args, reply)
+	return client.Call(ctx, serviceMethod, args, reply, opts...)
 }
 
 // 客户端对外提供的调用 rpc 接口的 api
 // 但是对于同一个地址的所有请求都是通过同一个 Client 来发送和接收的
-func (xc *XClient) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+// 创建一个 span 包裹负载均衡和真正的调用，Client.Call 的 span 是它的子节点
+func (xc *XClient) Call(ctx context.Context, serviceMethod string, args, reply interface{}, opts ...myrpc.CallOption) (err error) {
+	ctx, span := trace.Start(ctx, "xclient.Call "+serviceMethod, trace.KindInternal)
+	defer func() { span.Finish(err) }()
+	return xc.chain(xc.call)(ctx, serviceMethod, args, reply, opts...)
+}
+
+// 单向调用，按负载均衡策略选择一个 server 发送，不等待响应
+// 与 Call 一样创建一个 span，Client.Notify 的 span 是它的子节点
+func (xc *XClient) Notify(ctx context.Context, serviceMethod string, args interface{}, opts ...myrpc.CallOption) (err error) {
+	ctx, span := trace.Start(ctx, "xclient.Notify "+serviceMethod, trace.KindInternal)
+	defer func() { span.Finish(err) }()
+	return xc.chain(xc.notify)(ctx, serviceMethod, args, nil, opts...)
+}
+
+func (xc *XClient) notify(ctx context.Context, serviceMethod string, args, _ interface{}, opts ...myrpc.CallOption) error {
 	rpcAddr, err := xc.d.Get(xc.mode)
 	if err != nil {
 		return err
 	}
-	return xc.callWithAddr(rpcAddr, ctx, serviceMethod, args, reply)
+	client, err := xc.dial(rpcAddr)
+	if err != nil {
+		return err
+	}
+	return client.Notify(ctx, serviceMethod, args, opts...)
 }
 
-func (xc *XClient) Broadcast(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+// 每个 server 上的 Client.Call 都是同一个 span 的子节点
+func (xc *XClient) Broadcast(ctx context.Context, serviceMethod string, args, reply interface{}, opts ...myrpc.CallOption) (err error) {
+	ctx, span := trace.Start(ctx, "xclient.Broadcast "+serviceMethod, trace.KindInternal)
+	defer func() { span.Finish(err) }()
+	return xc.chain(xc.broadcast)(ctx, serviceMethod, args, reply, opts...)
+}
+
+func (xc *XClient) broadcast(ctx context.Context, serviceMethod string, args, reply interface{}, opts ...myrpc.CallOption) error {
 	servers, err := xc.d.GetAll()
 	if err != nil {
 		return err
 	}
+	xc.evict(servers)
+	if span := trace.SpanFromContext(ctx); span != nil {
+		span.SetAttribute("rpc.servers", strings.Join(servers, ","))
+	}
 	var wg sync.WaitGroup
 	var mu sync.Mutex
 	var e error
 	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 	for _, rpcAddr := range servers {
 		wg.Add(1)
 		go func(rpcAddr string) {
@@ -91,7 +136,7 @@ func (xc *XClient) Broadcast(ctx context.Context, serviceMethod string, args, re
 			if reply != nil { // 检查 rpc 方法是否有返回值
 				replyPtr = reflect.New(reflect.ValueOf(reply).Elem().Type()).Interface()
 			}
-			err := xc.callWithAddr(rpcAddr, ctx, serviceMethod, args, replyPtr)
+			err := xc.callWithAddr(rpcAddr, ctx, serviceMethod, args, replyPtr, opts...)
 			mu.Lock()
 			if err != nil && e == nil {
 				e = err